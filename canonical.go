@@ -0,0 +1,214 @@
+package keyval
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Equal returns true if other holds the same data, treating all numeric types as interchangeable so that a YAML
+// integer is equal to the same JSON number
+func (kv *KeyVal) Equal(other *KeyVal) bool {
+	return valuesEqual(kv.root, other.root)
+}
+
+// Canonical returns the RFC 8785 (JSON Canonicalization Scheme) representation of the data structure, in which keys
+// are sorted, whitespace is omitted and numbers and strings have a single permitted form
+func (kv *KeyVal) Canonical() ([]byte, error) {
+	var buf bytes.Buffer
+	err := writeCanonical(&buf, kv.root)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Hash returns the hex encoded SHA-256 digest of the canonical representation of the data structure.  Documents
+// which are Equal have the same hash, regardless of the format they were loaded from.
+func (kv *KeyVal) Hash() (string, error) {
+	return hashValue(kv.root)
+}
+
+// hashValue returns the hex encoded SHA-256 digest of the canonical representation of value
+func hashValue(value any) (string, error) {
+	var buf bytes.Buffer
+	err := writeCanonical(&buf, value)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// writeCanonical writes the canonical JSON representation of value to buf
+func writeCanonical(buf *bytes.Buffer, value any) error {
+	switch t := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(t))
+	case string:
+		writeCanonicalString(buf, t)
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for key := range t {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return lessUtf16(keys[i], keys[j])
+		})
+		buf.WriteByte('{')
+		for idx, key := range keys {
+			if idx > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, key)
+			buf.WriteByte(':')
+			err := writeCanonical(buf, t[key])
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []any:
+		buf.WriteByte('[')
+		for idx, item := range t {
+			if idx > 0 {
+				buf.WriteByte(',')
+			}
+			err := writeCanonical(buf, item)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		if num, ok := asNumber(value); ok {
+			text, err := canonicalNumber(num)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(text)
+			return nil
+		}
+
+		// Anything else is normalized through its regular JSON representation
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		var generic any
+		err = json.Unmarshal(data, &generic)
+		if err != nil {
+			return err
+		}
+		return writeCanonical(buf, generic)
+	}
+
+	return nil
+}
+
+// writeCanonicalString writes str as a JSON string, escaping only what RFC 8785 requires
+func writeCanonicalString(buf *bytes.Buffer, str string) {
+	buf.WriteByte('"')
+	for _, r := range str {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// canonicalNumber formats num as ECMAScript's Number.prototype.toString would, as required by RFC 8785
+func canonicalNumber(num float64) (string, error) {
+	if math.IsNaN(num) || math.IsInf(num, 0) {
+		return "", fmt.Errorf("Value %v cannot be represented in JSON", num)
+	}
+	if num == 0 {
+		return "0", nil
+	}
+
+	sign := ""
+	if num < 0 {
+		sign = "-"
+		num = -num
+	}
+
+	// Shortest round trip digits in the form d.ddddde±xx
+	mantissa, exponent, _ := strings.Cut(strconv.FormatFloat(num, 'e', -1, 64), "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	exp, err := strconv.Atoi(exponent)
+	if err != nil {
+		return "", err
+	}
+	k := len(digits)
+	n := exp + 1
+
+	var text string
+	switch {
+	case k <= n && n <= 21:
+		text = digits + strings.Repeat("0", n-k)
+	case 0 < n && n <= 21:
+		text = digits[:n] + "." + digits[n:]
+	case -6 < n && n <= 0:
+		text = "0." + strings.Repeat("0", -n) + digits
+	default:
+		expSign := "+"
+		if n-1 < 0 {
+			expSign = "-"
+		}
+		text = digits[:1]
+		if k > 1 {
+			text += "." + digits[1:]
+		}
+		text += "e" + expSign + strconv.Itoa(abs(n-1))
+	}
+
+	return sign + text, nil
+}
+
+// lessUtf16 returns true if a sorts before b when compared as UTF-16 code units
+func lessUtf16(a string, b string) bool {
+	unitsA := utf16.Encode([]rune(a))
+	unitsB := utf16.Encode([]rune(b))
+	for idx := 0; idx < len(unitsA) && idx < len(unitsB); idx++ {
+		if unitsA[idx] != unitsB[idx] {
+			return unitsA[idx] < unitsB[idx]
+		}
+	}
+	return len(unitsA) < len(unitsB)
+}
+
+// abs returns the absolute value of an integer
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
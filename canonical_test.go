@@ -0,0 +1,136 @@
+package keyval
+
+import (
+	"testing"
+)
+
+func TestEqual(t *testing.T) {
+	a, err := NewFromJson([]byte(`{"port": 80, "ratio": 0.5, "db": {"hosts": ["a", "b"]}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	b, err := NewFromYaml([]byte("db:\n  hosts: [a, b]\nratio: 0.5\nport: 80\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !a.Equal(b) {
+		t.Errorf("Expected documents to be equal")
+		return
+	}
+
+	err = b.SetValue([]any{"b", "a"}, "db", "hosts")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if a.Equal(b) {
+		t.Errorf("Expected documents to differ")
+		return
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	// Example from RFC 8785 section 3.2.2
+	kv, err := NewFromJson([]byte(`{
+		"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+		"string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+		"literals": [null, true, false]
+	}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := kv.Canonical()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s\n", expected, string(data))
+		return
+	}
+}
+
+func TestCanonicalNumbers(t *testing.T) {
+	cases := map[float64]string{
+		0:                      "0",
+		-1:                     "-1",
+		1e21:                   "1e+21",
+		1e20:                   "100000000000000000000",
+		123456789012345680000:  "123456789012345680000",
+		0.000001:               "0.000001",
+		1e-7:                   "1e-7",
+		5e-324:                 "5e-324",
+		1.7976931348623157e308: "1.7976931348623157e+308",
+		9007199254740992:       "9007199254740992",
+		-1.5e-10:               "-1.5e-10",
+	}
+	for num, expected := range cases {
+		text, err := canonicalNumber(num)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if text != expected {
+			t.Errorf("Expected %s, got %s", expected, text)
+		}
+	}
+}
+
+func TestCanonicalKeyOrder(t *testing.T) {
+	// Keys are ordered by UTF-16 code units, placing the surrogate pair for U+1F600 ahead of U+FB33
+	kv := NewFromMap(map[string]any{"דּ": 1, "\U0001f600": 2, "a": 3})
+	data, err := kv.Canonical()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := "{\"a\":3,\"\U0001f600\":2,\"דּ\":1}"
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s\n", expected, string(data))
+		return
+	}
+}
+
+func TestHash(t *testing.T) {
+	a, err := NewFromJson([]byte(`{"port": 80, "name": "app"}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	b, err := NewFromYaml([]byte("name: app\nport: 80\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	hashA, err := a.Hash()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	hashB, err := b.Hash()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if hashA != hashB {
+		t.Errorf("Expected equal hashes, got %s and %s", hashA, hashB)
+		return
+	}
+
+	b.SetValue(81, "port")
+	hashB, err = b.Hash()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if hashA == hashB {
+		t.Errorf("Expected hashes to differ")
+		return
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	formatJson       = "json"
+	formatYaml       = "yaml"
+	formatEnv        = "env"
+	formatProperties = "properties"
+)
+
+var (
+	envInvalidChars = regexp.MustCompile(`[^A-Z0-9_]`)
+	shellSafe       = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]+$`)
+)
+
+// flatEntry is a single scalar within a flattened document
+type flatEntry struct {
+	keys  []string
+	value any
+}
+
+// encode returns value in the given format.  The env and properties formats flatten nested mappings and arrays into
+// one line per scalar, and require value to be a mapping.
+func encode(value any, format string) ([]byte, error) {
+	switch format {
+	case formatJson:
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case formatYaml:
+		return yaml.Marshal(value)
+	case formatEnv, formatProperties:
+		if _, ok := value.(map[string]any); !ok {
+			return nil, fmt.Errorf("Only a mapping can be written as %s", format)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown format \"%s\"", format)
+	}
+
+	entries := []flatEntry{}
+	flatten(nil, value, &entries)
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		text, err := formatFlatValue(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		if format == formatEnv {
+			lines = append(lines, envName(entry.keys)+"="+shellQuote(text))
+		} else {
+			lines = append(lines, escapeProperty(strings.Join(entry.keys, "."), true)+"="+escapeProperty(text, false))
+		}
+	}
+	sort.Strings(lines)
+	if len(lines) == 0 {
+		return []byte{}, nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// flatten appends an entry for every scalar beneath value.  Empty mappings and arrays are treated as scalars so they
+// aren't lost.
+func flatten(keys []string, value any, entries *[]flatEntry) {
+	switch t := value.(type) {
+	case map[string]any:
+		if len(t) > 0 {
+			for key, item := range t {
+				flatten(append(append([]string{}, keys...), key), item, entries)
+			}
+			return
+		}
+	case []any:
+		if len(t) > 0 {
+			for idx, item := range t {
+				flatten(append(append([]string{}, keys...), fmt.Sprint(idx)), item, entries)
+			}
+			return
+		}
+	}
+	*entries = append(*entries, flatEntry{keys, value})
+}
+
+// formatFlatValue returns the text of a flattened value, strings being unquoted and everything else JSON
+func formatFlatValue(value any) (string, error) {
+	if str, ok := value.(string); ok {
+		return str, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// envName returns the environment variable name for keys, eg. ["db", "max-conns"] becomes DB_MAX_CONNS
+func envName(keys []string) string {
+	name := envInvalidChars.ReplaceAllString(strings.ToUpper(strings.Join(keys, "_")), "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// shellQuote returns text quoted for use in a POSIX shell, where necessary
+func shellQuote(text string) string {
+	if shellSafe.MatchString(text) {
+		return text
+	}
+	return "'" + strings.ReplaceAll(text, "'", `'\''`) + "'"
+}
+
+// escapeProperty escapes text for use in a Java properties file, isKey indicating that separators must be escaped
+func escapeProperty(text string, isKey bool) string {
+	var sb strings.Builder
+	for idx, r := range text {
+		switch {
+		case r == '\\':
+			sb.WriteString(`\\`)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r == '\f':
+			sb.WriteString(`\f`)
+		case (r == '=' || r == ':' || r == '#' || r == '!') && isKey:
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r == ' ' && (isKey || idx == 0):
+			sb.WriteString(`\ `)
+		case r > 0x7e || r < 0x20:
+			for _, unit := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(&sb, `\u%04x`, unit)
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
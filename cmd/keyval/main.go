@@ -0,0 +1,564 @@
+// Command keyval reads, modifies, merges, converts, compares and validates JSON and YAML documents using keyval's
+// semantics.  Usage:
+//
+//	keyval get [flags] <file> <key>
+//	keyval set [flags] <file> <key> <value>
+//	keyval delete [flags] <file> <key>
+//	keyval merge [flags] <file> <file>...
+//	keyval convert [flags] <file>
+//	keyval diff [flags] <file> <file>
+//	keyval validate [flags] -schema <schema> <file>
+//	keyval query [flags] <file> <pattern>
+//
+// A file of "-" refers to stdin.  Keys are split on "." (see -d), and within a query pattern a "*" matches any key or
+// array index.  The exit status is 0 on success, 1 if diff found differences or validate found violations, and 2 on
+// error.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashibuto/keyval"
+)
+
+const (
+	exitOk       = 0
+	exitDiffers  = 1
+	exitFailure  = 2
+	stdinName    = "-"
+	defaultDelim = "."
+)
+
+// errUsage indicates that the command line was invalid, the usage having already been printed
+var errUsage = errors.New("invalid usage")
+
+// command is a single subcommand
+type command struct {
+	description string
+	run         func(c *cli, args []string) (int, error)
+}
+
+// commands holds every subcommand by name
+var commands = map[string]command{
+	"get": {
+		description: "Print the value at key",
+		run:         (*cli).get,
+	},
+	"set": {
+		description: "Set the value at key, creating any missing parents",
+		run:         (*cli).set,
+	},
+	"delete": {
+		description: "Remove the value at key",
+		run:         (*cli).delete,
+	},
+	"merge": {
+		description: "Stack each file atop the previous ones",
+		run:         (*cli).merge,
+	},
+	"convert": {
+		description: "Convert a document to another format",
+		run:         (*cli).convert,
+	},
+	"diff": {
+		description: "Show the differences between two documents",
+		run:         (*cli).diff,
+	},
+	"validate": {
+		description: "Validate a document against a JSON schema",
+		run:         (*cli).validate,
+	},
+	"query": {
+		description: "Print every value matching a key pattern",
+		run:         (*cli).query,
+	},
+}
+
+// cli holds the streams used by the subcommands
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	c := &cli{
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	os.Exit(c.run(os.Args[1:]))
+}
+
+// run executes the subcommand named by args[0], returning the exit status
+func (c *cli) run(args []string) int {
+	if len(args) == 0 {
+		c.usage()
+		return exitFailure
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(c.stderr, "keyval: unknown command \"%s\"\n", args[0])
+		c.usage()
+		return exitFailure
+	}
+
+	status, err := cmd.run(c, args[1:])
+	if err != nil {
+		if err != errUsage {
+			fmt.Fprintf(c.stderr, "keyval %s: %v\n", args[0], err)
+		}
+		return exitFailure
+	}
+	return status
+}
+
+// usage prints the list of subcommands
+func (c *cli) usage() {
+	fmt.Fprintln(c.stderr, "Usage: keyval <command> [flags] <args>")
+	fmt.Fprintln(c.stderr, "\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(c.stderr, "  %-9s %s\n", name, commands[name].description)
+	}
+}
+
+// flagSet returns a new flag set for the named subcommand, usage describing its positional arguments
+func (c *cli) flagSet(name string, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: keyval %s [flags] %s\n", name, usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parse parses args with flags, requiring at least min positional arguments and at most max (-1 for no limit)
+func (c *cli) parse(flags *flag.FlagSet, args []string, min int, max int) ([]string, error) {
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, errUsage
+	}
+	positional := flags.Args()
+	if len(positional) < min || (max >= 0 && len(positional) > max) {
+		flags.Usage()
+		return nil, errUsage
+	}
+	return positional, nil
+}
+
+// get prints the value at a key
+func (c *cli) get(args []string) (int, error) {
+	flags := c.flagSet("get", "<file> <key>")
+	output := flags.String("o", "", "Output format for mappings and arrays: json, yaml, env or properties (defaults to the input format)")
+	delim := flags.String("d", defaultDelim, "Key delimiter")
+	positional, err := c.parse(flags, args, 2, 2)
+	if err != nil {
+		return exitFailure, err
+	}
+
+	doc, err := c.load(positional[0])
+	if err != nil {
+		return exitFailure, err
+	}
+	v, err := doc.kv.Value(splitKey(positional[1], *delim)...)
+	if err != nil {
+		return exitFailure, err
+	}
+
+	switch t := v.(type) {
+	case map[string]any, []any:
+		return exitOk, c.write(t, outputFormat(*output, doc.format))
+	case string:
+		// Strings are printed raw, making them convenient to consume from shell scripts
+		_, err = fmt.Fprintln(c.stdout, t)
+		return exitOk, err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return exitFailure, err
+	}
+	_, err = fmt.Fprintln(c.stdout, string(data))
+	return exitOk, err
+}
+
+// set sets the value at a key
+func (c *cli) set(args []string) (int, error) {
+	flags := c.flagSet("set", "<file> <key> <value>")
+	output := flags.String("o", "", "Output format: json, yaml, env or properties (defaults to the input format)")
+	delim := flags.String("d", defaultDelim, "Key delimiter")
+	inPlace := flags.Bool("i", false, "Modify the file in place rather than printing the result")
+	asString := flags.Bool("s", false, "Treat the value as a string, rather than as JSON where it is valid JSON")
+	positional, err := c.parse(flags, args, 3, 3)
+	if err != nil {
+		return exitFailure, err
+	}
+
+	doc, err := c.load(positional[0])
+	if err != nil {
+		return exitFailure, err
+	}
+	var value any = positional[2]
+	if !*asString {
+		var parsed any
+		if json.Unmarshal([]byte(positional[2]), &parsed) == nil {
+			value = parsed
+		}
+	}
+	keys := splitKey(positional[1], *delim)
+	if len(keys) == 0 {
+		return exitFailure, fmt.Errorf("A key is required")
+	}
+	err = doc.kv.CreateValue(value, keys...)
+	if err != nil {
+		return exitFailure, err
+	}
+	return exitOk, c.save(doc, *output, *inPlace)
+}
+
+// delete removes the value at a key
+func (c *cli) delete(args []string) (int, error) {
+	flags := c.flagSet("delete", "<file> <key>")
+	output := flags.String("o", "", "Output format: json, yaml, env or properties (defaults to the input format)")
+	delim := flags.String("d", defaultDelim, "Key delimiter")
+	inPlace := flags.Bool("i", false, "Modify the file in place rather than printing the result")
+	positional, err := c.parse(flags, args, 2, 2)
+	if err != nil {
+		return exitFailure, err
+	}
+
+	doc, err := c.load(positional[0])
+	if err != nil {
+		return exitFailure, err
+	}
+	err = doc.kv.DeleteValue(splitKey(positional[1], *delim)...)
+	if err != nil {
+		return exitFailure, err
+	}
+	return exitOk, c.save(doc, *output, *inPlace)
+}
+
+// merge stacks each document atop the previous ones
+func (c *cli) merge(args []string) (int, error) {
+	flags := c.flagSet("merge", "<file> <file>...")
+	output := flags.String("o", "", "Output format: json, yaml, env or properties (defaults to the format of the first file)")
+	positional, err := c.parse(flags, args, 1, -1)
+	if err != nil {
+		return exitFailure, err
+	}
+
+	var merged *document
+	for _, name := range positional {
+		doc, err := c.load(name)
+		if err != nil {
+			return exitFailure, err
+		}
+		if merged == nil {
+			merged = doc
+		} else {
+			merged.kv = merged.kv.Stack(doc.kv)
+		}
+	}
+	return exitOk, c.writeDocument(merged.kv, outputFormat(*output, merged.format))
+}
+
+// convert writes a document in another format
+func (c *cli) convert(args []string) (int, error) {
+	flags := c.flagSet("convert", "<file>")
+	output := flags.String("o", "", "Output format: json, yaml, env or properties (defaults to the opposite of the input format)")
+	positional, err := c.parse(flags, args, 1, 1)
+	if err != nil {
+		return exitFailure, err
+	}
+
+	doc, err := c.load(positional[0])
+	if err != nil {
+		return exitFailure, err
+	}
+	format := *output
+	if format == "" {
+		format = formatJson
+		if doc.format == formatJson {
+			format = formatYaml
+		}
+	}
+	return exitOk, c.writeDocument(doc.kv, format)
+}
+
+// diff shows the differences between two documents
+func (c *cli) diff(args []string) (int, error) {
+	flags := c.flagSet("diff", "<file> <file>")
+	style := flags.String("f", "tree", "Output style: tree, markdown, unified or json")
+	color := flags.Bool("color", false, "Color the tree output")
+	context := flags.Int("U", keyval.DefaultUnifiedContext, "Lines of context in unified output")
+	ignore := flags.String("ignore", "", "Comma separated JSON pointers to exclude from the comparison")
+	tolerance := flags.Float64("tolerance", 0, "Largest difference at which numbers are considered equal")
+	positional, err := c.parse(flags, args, 2, 2)
+	if err != nil {
+		return exitFailure, err
+	}
+
+	a, err := c.load(positional[0])
+	if err != nil {
+		return exitFailure, err
+	}
+	b, err := c.load(positional[1])
+	if err != nil {
+		return exitFailure, err
+	}
+
+	opts := keyval.DiffOptions{
+		FloatTolerance: *tolerance,
+	}
+	if *ignore != "" {
+		opts.IgnorePaths = strings.Split(*ignore, ",")
+	}
+	changes := keyval.DiffWithOptions(a.kv, b.kv, opts)
+	if len(changes) == 0 {
+		return exitOk, nil
+	}
+
+	lines := *context
+	if lines == 0 {
+		// UnifiedOptions treats zero as the default, and a negative count as no context
+		lines = -1
+	}
+	var text string
+	switch *style {
+	case "tree":
+		text = keyval.RenderTree(changes, *color)
+	case "markdown":
+		text = keyval.RenderMarkdown(changes)
+	case "unified":
+		text, err = keyval.UnifiedDiff(a.kv, b.kv, keyval.UnifiedOptions{
+			FromName: positional[0],
+			ToName:   positional[1],
+			Context:  lines,
+			Json:     a.format == formatJson && b.format == formatJson,
+		})
+	case "json":
+		var data []byte
+		data, err = json.MarshalIndent(changes, "", "  ")
+		text = string(data) + "\n"
+	default:
+		return exitFailure, fmt.Errorf("Unknown output style \"%s\"", *style)
+	}
+	if err != nil {
+		return exitFailure, err
+	}
+	_, err = io.WriteString(c.stdout, text)
+	return exitDiffers, err
+}
+
+// validate validates a document against a JSON schema
+func (c *cli) validate(args []string) (int, error) {
+	flags := c.flagSet("validate", "-schema <schema> <file>")
+	schemaName := flags.String("schema", "", "JSON schema file")
+	positional, err := c.parse(flags, args, 1, 1)
+	if err != nil {
+		return exitFailure, err
+	}
+	if *schemaName == "" {
+		flags.Usage()
+		return exitFailure, errUsage
+	}
+
+	schema, err := c.load(*schemaName)
+	if err != nil {
+		return exitFailure, err
+	}
+	doc, err := c.load(positional[0])
+	if err != nil {
+		return exitFailure, err
+	}
+	violations, err := doc.kv.Validate(schema.kv)
+	if err != nil {
+		return exitFailure, err
+	}
+	for _, violation := range violations {
+		_, err = fmt.Fprintln(c.stdout, violation.Error())
+		if err != nil {
+			return exitFailure, err
+		}
+	}
+	if len(violations) > 0 {
+		return exitDiffers, nil
+	}
+	return exitOk, nil
+}
+
+// query prints every value matching a key pattern as a line of JSON
+func (c *cli) query(args []string) (int, error) {
+	flags := c.flagSet("query", "<file> <pattern>")
+	delim := flags.String("d", defaultDelim, "Key delimiter")
+	withPaths := flags.Bool("p", false, "Prefix each value with its JSON pointer and a tab")
+	positional, err := c.parse(flags, args, 2, 2)
+	if err != nil {
+		return exitFailure, err
+	}
+
+	doc, err := c.load(positional[0])
+	if err != nil {
+		return exitFailure, err
+	}
+	root, err := doc.kv.Mapping()
+	if err != nil {
+		return exitFailure, err
+	}
+
+	for _, m := range query(root, splitKey(positional[1], *delim)) {
+		data, err := json.Marshal(m.value)
+		if err != nil {
+			return exitFailure, err
+		}
+		if *withPaths {
+			_, err = fmt.Fprintf(c.stdout, "%s\t%s\n", m.path, data)
+		} else {
+			_, err = fmt.Fprintf(c.stdout, "%s\n", data)
+		}
+		if err != nil {
+			return exitFailure, err
+		}
+	}
+	return exitOk, nil
+}
+
+// document is a parsed input file
+type document struct {
+	name   string
+	format string
+	kv     *keyval.KeyVal
+}
+
+// load reads and parses the named file, or stdin if name is "-"
+func (c *cli) load(name string) (*document, error) {
+	var data []byte
+	var err error
+	if name == stdinName {
+		data, err = io.ReadAll(c.stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &document{
+		name:   name,
+		format: detectFormat(name, data),
+	}
+	if doc.format == formatJson {
+		doc.kv, err = keyval.NewFromJson(data)
+	} else {
+		doc.kv, err = keyval.NewFromYaml(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse \"%s\": %v", name, err)
+	}
+	return doc, nil
+}
+
+// save writes a modified document to stdout, or back to its file when inPlace is set
+func (c *cli) save(doc *document, output string, inPlace bool) error {
+	format := outputFormat(output, doc.format)
+	if !inPlace {
+		return c.writeDocument(doc.kv, format)
+	}
+	if doc.name == stdinName {
+		return fmt.Errorf("Cannot modify stdin in place")
+	}
+
+	root, err := doc.kv.Mapping()
+	if err != nil {
+		return err
+	}
+	data, err := encode(root, format)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(doc.name)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that the original survives a failure
+	tmp, err := os.CreateTemp(filepath.Dir(doc.name), "."+filepath.Base(doc.name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), doc.name)
+}
+
+// writeDocument writes an entire document to stdout
+func (c *cli) writeDocument(kv *keyval.KeyVal, format string) error {
+	root, err := kv.Mapping()
+	if err != nil {
+		return err
+	}
+	return c.write(root, format)
+}
+
+// write writes value to stdout in format
+func (c *cli) write(value any, format string) error {
+	data, err := encode(value, format)
+	if err != nil {
+		return err
+	}
+	_, err = c.stdout.Write(data)
+	return err
+}
+
+// detectFormat returns the format of the named file, inspecting its content where the extension doesn't say
+func detectFormat(name string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return formatJson
+	case ".yaml", ".yml":
+		return formatYaml
+	}
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(trimmed) {
+		return formatJson
+	}
+	return formatYaml
+}
+
+// outputFormat returns the requested output format, or the input format if none was requested
+func outputFormat(requested string, input string) string {
+	if requested != "" {
+		return requested
+	}
+	return input
+}
+
+// splitKey splits key into its components, an empty key referring to the document root
+func splitKey(key string, delim string) []string {
+	if key == "" {
+		return []string{}
+	}
+	return keyval.SplitKey(key, delim)
+}
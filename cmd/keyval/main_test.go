@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCli runs the command line with stdin, returning the exit status and output
+func runCli(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	c := &cli{
+		stdin:  strings.NewReader(stdin),
+		stdout: &stdout,
+		stderr: &stderr,
+	}
+	status := c.run(args)
+	return status, stdout.String(), stderr.String()
+}
+
+// writeFile writes a file within dir, returning its path
+func writeFile(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetSetDelete(t *testing.T) {
+	input := `{"db": {"host": "localhost", "port": 5432, "tags": ["a", "b"]}}`
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"get", "-", "db.host"}, "localhost\n"},
+		{[]string{"get", "-", "db.port"}, "5432\n"},
+		{[]string{"get", "-o", "yaml", "-", "db.tags"}, "- a\n- b\n"},
+		{[]string{"set", "-o", "env", "-", "db.port", "6543"}, "DB_HOST=localhost\nDB_PORT=6543\nDB_TAGS_0=a\nDB_TAGS_1=b\n"},
+		{[]string{"set", "-s", "-o", "env", "-", "db.port", "6543"}, "DB_HOST=localhost\nDB_PORT=6543\nDB_TAGS_0=a\nDB_TAGS_1=b\n"},
+		{[]string{"set", "-d", "/", "-o", "properties", "-", "cache/ttl", "60"}, "cache.ttl=60\ndb.host=localhost\ndb.port=5432\ndb.tags.0=a\ndb.tags.1=b\n"},
+		{[]string{"delete", "-o", "env", "-", "db.tags"}, "DB_HOST=localhost\nDB_PORT=5432\n"},
+	}
+
+	for _, test := range tests {
+		status, stdout, stderr := runCli(input, test.args...)
+		if status != exitOk {
+			t.Errorf("%v: Unexpected status %d: %s", test.args, status, stderr)
+			continue
+		}
+		if stdout != test.expected {
+			t.Errorf("%v: Expected %q, got %q", test.args, test.expected, stdout)
+		}
+	}
+}
+
+func TestSetInPlace(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yaml", "name: test\n")
+
+	status, stdout, stderr := runCli("", "set", "-i", path, "server.port", "8080")
+	if status != exitOk || stdout != "" {
+		t.Errorf("Unexpected status %d, output %q: %s", status, stdout, stderr)
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := "name: test\nserver:\n    port: 8080\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(data))
+	}
+}
+
+func TestMergeConvert(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.json", `{"a": 1, "b": {"c": 2, "d": 3}}`)
+	override := writeFile(t, dir, "override.yaml", "b:\n  c: 4\n")
+
+	status, stdout, stderr := runCli("", "merge", base, override)
+	if status != exitOk {
+		t.Errorf("Unexpected status %d: %s", status, stderr)
+		return
+	}
+	expected := "{\n  \"a\": 1,\n  \"b\": {\n    \"c\": 4,\n    \"d\": 3\n  }\n}\n"
+	if stdout != expected {
+		t.Errorf("Expected %q, got %q", expected, stdout)
+		return
+	}
+
+	status, stdout, stderr = runCli(stdout, "convert", "-")
+	if status != exitOk {
+		t.Errorf("Unexpected status %d: %s", status, stderr)
+		return
+	}
+	expected = "a: 1\nb:\n    c: 4\n    d: 3\n"
+	if stdout != expected {
+		t.Errorf("Expected %q, got %q", expected, stdout)
+	}
+}
+
+func TestDiffValidate(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.json", `{"name": "x", "port": 80}`)
+	b := writeFile(t, dir, "b.yaml", "name: x\nport: 8080\n")
+	schema := writeFile(t, dir, "schema.json", `{"type": "object", "properties": {"port": {"type": "integer", "maximum": 1024}}}`)
+
+	status, stdout, _ := runCli("", "diff", a, a)
+	if status != exitOk || stdout != "" {
+		t.Errorf("Expected no differences, got %d %q", status, stdout)
+		return
+	}
+	status, stdout, _ = runCli("", "diff", a, b)
+	if status != exitDiffers || stdout != "~ port: 80 -> 8080\n" {
+		t.Errorf("Unexpected diff %d %q", status, stdout)
+		return
+	}
+
+	status, stdout, _ = runCli("", "validate", "-schema", schema, a)
+	if status != exitOk || stdout != "" {
+		t.Errorf("Expected a valid document, got %d %q", status, stdout)
+		return
+	}
+	status, stdout, _ = runCli("", "validate", "-schema", schema, b)
+	if status != exitDiffers || !strings.HasPrefix(stdout, "/port: ") {
+		t.Errorf("Expected a violation, got %d %q", status, stdout)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	input := "servers:\n  - name: a\n    port: 1\n  - name: b\n    port: 2\nother: {name: c}\n"
+
+	status, stdout, stderr := runCli(input, "query", "-p", "-", "servers.*.name")
+	if status != exitOk {
+		t.Errorf("Unexpected status %d: %s", status, stderr)
+		return
+	}
+	expected := "/servers/0/name\t\"a\"\n/servers/1/name\t\"b\"\n"
+	if stdout != expected {
+		t.Errorf("Expected %q, got %q", expected, stdout)
+		return
+	}
+
+	_, stdout, _ = runCli(input, "query", "-", "*.name")
+	if stdout != "\"c\"\n" {
+		t.Errorf("Expected only other.name, got %q", stdout)
+		return
+	}
+	_, stdout, _ = runCli(input, "query", "-", "servers.1.port")
+	if stdout != "2\n" {
+		t.Errorf("Expected 2, got %q", stdout)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := [][]string{
+		{},
+		{"bogus"},
+		{"get", "-"},
+		{"get", "-", "missing"},
+		{"convert", "-o", "xml", "-"},
+		{"set", "-i", "-", "a", "1"},
+	}
+	for _, args := range tests {
+		status, _, stderr := runCli("{}", args...)
+		if status != exitFailure || stderr == "" {
+			t.Errorf("%v: Expected failure, got %d", args, status)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// queryWildcard matches any key or array index within a query pattern
+const queryWildcard = "*"
+
+// match is a value located by a query
+type match struct {
+	// path is a JSON pointer to the value
+	path  string
+	value any
+}
+
+// query returns every value within root matching pattern.  Each component of the pattern selects the key of a
+// mapping or the index of an array, "*" selecting all of them.  Matches are returned in document order, with the
+// keys of mappings sorted.
+func query(root any, pattern []string) []match {
+	matches := []match{}
+	queryValue(root, pattern, "", &matches)
+	return matches
+}
+
+// queryValue appends the matches for pattern beneath value, which is located at path
+func queryValue(value any, pattern []string, path string, matches *[]match) {
+	if len(pattern) == 0 {
+		*matches = append(*matches, match{path, value})
+		return
+	}
+
+	token, rest := pattern[0], pattern[1:]
+	switch t := value.(type) {
+	case map[string]any:
+		if token != queryWildcard {
+			if item, ok := t[token]; ok {
+				queryValue(item, rest, childPath(path, token), matches)
+			}
+			return
+		}
+		keys := make([]string, 0, len(t))
+		for key := range t {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			queryValue(t[key], rest, childPath(path, key), matches)
+		}
+	case []any:
+		if token != queryWildcard {
+			idx, err := strconv.Atoi(token)
+			if err == nil && idx >= 0 && idx < len(t) {
+				queryValue(t[idx], rest, childPath(path, token), matches)
+			}
+			return
+		}
+		for idx, item := range t {
+			queryValue(item, rest, childPath(path, fmt.Sprint(idx)), matches)
+		}
+	}
+}
+
+// childPath returns the JSON pointer to key beneath path
+func childPath(path string, key string) string {
+	key = strings.ReplaceAll(key, "~", "~0")
+	key = strings.ReplaceAll(key, "/", "~1")
+	return path + "/" + key
+}
@@ -0,0 +1,75 @@
+// Command keyvalgen generates Go struct definitions from a sample JSON/YAML document or a JSON schema.  It is
+// intended for use with go generate, eg:
+//
+//	//go:generate go run github.com/hashibuto/keyval/cmd/keyvalgen -in config.yaml -type Config -out config_gen.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashibuto/keyval"
+)
+
+func main() {
+	in := flag.String("in", "-", "Input JSON or YAML file, or - for stdin")
+	out := flag.String("out", "-", "Output Go file, or - for stdout")
+	pkg := flag.String("package", os.Getenv("GOPACKAGE"), "Generated package name (defaults to $GOPACKAGE)")
+	typeName := flag.String("type", "Config", "Name of the top level struct")
+	isSchema := flag.Bool("schema", false, "Treat the input as a JSON schema rather than a sample document")
+	flag.Parse()
+
+	err := run(*in, *out, *pkg, *typeName, *isSchema)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "keyvalgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run performs a single generation
+func run(in string, out string, pkg string, typeName string, isSchema bool) error {
+	var data []byte
+	var err error
+	if in == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(in)
+	}
+	if err != nil {
+		return err
+	}
+
+	var kv *keyval.KeyVal
+	if strings.ToLower(filepath.Ext(in)) == ".json" {
+		kv, err = keyval.NewFromJson(data)
+	} else {
+		kv, err = keyval.NewFromYaml(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	schema := kv
+	if !isSchema {
+		schema = keyval.InferSchema(kv)
+	}
+
+	src, err := keyval.GenerateStructs(schema, keyval.GenerateOptions{
+		Package:   pkg,
+		TypeName:  typeName,
+		Generator: "keyvalgen",
+	})
+	if err != nil {
+		return err
+	}
+
+	if out == "-" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(out, src, 0644)
+}
@@ -0,0 +1,154 @@
+package keyval
+
+import (
+	"fmt"
+)
+
+// FillDefaults inserts every key from defaults which is absent from the document, returning JSON pointers to the
+// keys which were defaulted.  Unlike Stack, keys which are already present are never replaced, even when their value
+// is null.  Nested mappings present in both documents are filled recursively.
+func (kv *KeyVal) FillDefaults(defaults *KeyVal) ([]string, error) {
+	if kv.frozen {
+		return nil, ErrFrozen
+	}
+	filled := []string{}
+	// Only the mappings at the positions of the defaults' mappings are filled in place
+	err := kv.notifyDocument(defaults.root, func() error {
+		owned := kv.ownership()
+		kv.root = kv.own(owned, kv.root)
+		kv.fillDefaults(owned, kv.root, defaults.root, nil, &filled)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return filled, nil
+}
+
+// FillSchemaDefaults inserts the "default" of every schema property which is absent from the document, returning
+// JSON pointers to the keys which were defaulted.  Defaults are applied within nested objects described by
+// "properties", to every element of arrays described by "items", and through "allOf" and local "$ref" keywords.  The
+// defaults are applied to a copy of the document, so if the schema can't be applied, the document is left untouched.
+func (kv *KeyVal) FillSchemaDefaults(schema *KeyVal) ([]string, error) {
+	if kv.frozen {
+		return nil, ErrFrozen
+	}
+	filler := &defaultFiller{
+		root:   schema.root,
+		filled: []string{},
+	}
+	root := deepCopy(kv.root).(map[string]any)
+	err := filler.fill(schema.root, root, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = kv.notifyDocument(map[string]any{}, func() error {
+		kv.root = root
+		kv.setOwnership(fullOwnership())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return filler.filled, nil
+}
+
+// fillDefaults inserts keys from defaults absent in target, located at path, where target is owned by the KeyVal as
+// recorded by owned
+func (kv *KeyVal) fillDefaults(owned *ownership, target map[string]any, defaults map[string]any, path []string, filled *[]string) {
+	for _, key := range sortedKeys(defaults) {
+		defaultVal := defaults[key]
+		origVal, ok := target[key]
+		if !ok {
+			target[key] = deepCopy(defaultVal)
+			owned.claim(key)
+			*filled = append(*filled, formatPointer(appendPath(path, key)...))
+			continue
+		}
+		if isMapping(origVal) && isMapping(defaultVal) {
+			child := owned.child(key)
+			mapping := kv.own(child, origVal.(map[string]any))
+			target[key] = mapping
+			kv.fillDefaults(child, mapping, defaultVal.(map[string]any), appendPath(path, key), filled)
+		}
+	}
+}
+
+// defaultFiller applies schema defaults to a document
+type defaultFiller struct {
+	root     map[string]any
+	filled   []string
+	refDepth int
+}
+
+// fill applies the defaults described by schema to value, located at path
+func (f *defaultFiller) fill(schema any, value any, path []string) error {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	if raw, ok := s["$ref"]; ok {
+		target, err := resolveSchemaRef(f.root, raw)
+		if err != nil {
+			return err
+		}
+		if f.refDepth >= maxRefDepth {
+			return fmt.Errorf("Schema reference depth limit exceeded resolving \"%v\"", raw)
+		}
+		f.refDepth++
+		err = f.fill(target, value, path)
+		f.refDepth--
+		if err != nil {
+			return err
+		}
+	}
+
+	if allOf, ok := s["allOf"].([]any); ok {
+		for _, subSchema := range allOf {
+			err := f.fill(subSchema, value, path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	switch t := value.(type) {
+	case map[string]any:
+		properties, ok := s["properties"].(map[string]any)
+		if !ok {
+			return nil
+		}
+		for _, key := range sortedKeys(properties) {
+			propSchema, ok := properties[key].(map[string]any)
+			if !ok {
+				continue
+			}
+			if _, ok := t[key]; !ok {
+				defaultVal, ok := propSchema["default"]
+				if !ok {
+					continue
+				}
+				t[key] = deepCopy(defaultVal)
+				f.filled = append(f.filled, formatPointer(appendPath(path, key)...))
+			}
+			err := f.fill(propSchema, t[key], appendPath(path, key))
+			if err != nil {
+				return err
+			}
+		}
+	case []any:
+		items, ok := s["items"]
+		if !ok {
+			return nil
+		}
+		for idx, item := range t {
+			err := f.fill(items, item, appendPath(path, fmt.Sprint(idx)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package keyval
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFillDefaults(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"name": "app", "db": {"host": "db1", "password": null}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defaults, err := NewFromJson([]byte(`{"name": "default", "port": 80, "db": {"host": "localhost", "password": "secret", "pool": {"size": 4}}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	filled, err := kv.FillDefaults(defaults)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := json.Marshal(kv.root)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := `{"db":{"host":"db1","password":null,"pool":{"size":4}},"name":"app","port":80}`
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s\n", expected, string(data))
+		return
+	}
+	if len(filled) != 2 || filled[0] != "/db/pool" || filled[1] != "/port" {
+		t.Errorf("Unexpected filled keys %v", filled)
+		return
+	}
+
+	err = kv.SetValue(8, "db", "pool", "size")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	size, err := defaults.Number("db", "pool", "size")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if size != 4 {
+		t.Errorf("Defaults document was modified")
+		return
+	}
+}
+
+func TestFillSchemaDefaults(t *testing.T) {
+	schema, err := NewFromJson([]byte(`{
+		"properties": {
+			"port": {"type": "integer", "default": 80},
+			"mode": {"default": "dev"},
+			"servers": {"type": "array", "items": {"$ref": "#/$defs/server"}}
+		},
+		"$defs": {
+			"server": {"properties": {"host": {"default": "localhost"}, "weight": {"default": 1}}}
+		}
+	}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	kv, err := NewFromJson([]byte(`{"mode": null, "servers": [{"host": "a"}, {"weight": 5}]}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	filled, err := kv.FillSchemaDefaults(schema)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := json.Marshal(kv.root)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := `{"mode":null,"port":80,"servers":[{"host":"a","weight":1},{"host":"localhost","weight":5}]}`
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s\n", expected, string(data))
+		return
+	}
+	if len(filled) != 3 || filled[0] != "/port" || filled[1] != "/servers/0/weight" || filled[2] != "/servers/1/host" {
+		t.Errorf("Unexpected filled keys %v", filled)
+		return
+	}
+}
+
+func TestFillDefaultsErrors(t *testing.T) {
+	frozen := NewStore(New()).Load()
+	_, err := frozen.FillDefaults(New())
+	if err != ErrFrozen {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+		return
+	}
+
+	// A schema failing part way through leaves the document untouched
+	schema, err := NewFromJson([]byte(`{
+		"properties": {
+			"a": {"default": 1},
+			"b": {"$ref": "#/$defs/missing"}
+		}
+	}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	kv, err := NewFromJson([]byte(`{"b": {}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = kv.FillSchemaDefaults(schema)
+	if err == nil {
+		t.Errorf("Expected an error resolving the missing reference")
+		return
+	}
+	expectJson(t, kv, `{"b":{}}`)
+}
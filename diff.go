@@ -0,0 +1,242 @@
+package keyval
+
+import (
+	"fmt"
+	"math"
+)
+
+// ChangeKind describes how a value differs between two documents
+type ChangeKind string
+
+const (
+	ChangeAdded       ChangeKind = "added"
+	ChangeRemoved     ChangeKind = "removed"
+	ChangeModified    ChangeKind = "modified"
+	ChangeTypeChanged ChangeKind = "type-changed"
+)
+
+// Change describes a single difference between two documents
+type Change struct {
+	// Path is a JSON pointer to the changed value, the empty string refers to the document root
+	Path string
+	// Keys holds the unescaped components of Path
+	Keys []string
+	Kind ChangeKind
+	// Old is the original value, nil if the value was added
+	Old any
+	// New is the updated value, nil if the value was removed
+	New any
+}
+
+// DiffOptions controls the comparison performed by DiffWithOptions.  Paths are JSON pointers, in which a "*" token
+// matches any single key or array index.
+type DiffOptions struct {
+	// IgnorePaths lists values which are excluded from the comparison, along with everything beneath them
+	IgnorePaths []string
+	// FloatTolerance is the largest difference at which two numbers are still considered equal
+	FloatTolerance float64
+	// ArrayKeys maps the path of an array of objects to the key identifying each of its elements.  Elements of such
+	// an array are matched by identity rather than by position, so reordering them isn't reported as a change.
+	ArrayKeys map[string]string
+}
+
+// differ compares documents according to a set of options
+type differ struct {
+	ignore    [][]string
+	tolerance float64
+	arrayKeys []arrayKey
+	changes   []Change
+}
+
+// arrayKey pairs the path of an array with the key identifying its elements
+type arrayKey struct {
+	pattern []string
+	key     string
+}
+
+// Diff returns the changes required to transform document a into document b
+func Diff(a *KeyVal, b *KeyVal) []Change {
+	return DiffWithOptions(a, b, DiffOptions{})
+}
+
+// DiffWithOptions returns the changes required to transform document a into document b, as governed by opts
+func DiffWithOptions(a *KeyVal, b *KeyVal, opts DiffOptions) []Change {
+	d := &differ{
+		tolerance: opts.FloatTolerance,
+		changes:   []Change{},
+	}
+	for _, pattern := range opts.IgnorePaths {
+		tokens, err := parsePointer(pattern)
+		if err == nil {
+			d.ignore = append(d.ignore, tokens)
+		}
+	}
+	for pattern, key := range opts.ArrayKeys {
+		tokens, err := parsePointer(pattern)
+		if err == nil {
+			d.arrayKeys = append(d.arrayKeys, arrayKey{pattern: tokens, key: key})
+		}
+	}
+
+	d.diff(nil, a.root, b.root)
+	return d.changes
+}
+
+// diff records the changes between a and b, both located at path
+func (d *differ) diff(path []string, a any, b any) {
+	if d.ignored(path) {
+		return
+	}
+
+	switch t := a.(type) {
+	case map[string]any:
+		other, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		for _, key := range sortedKeys(t) {
+			otherVal, ok := other[key]
+			if ok {
+				d.diff(appendPath(path, key), t[key], otherVal)
+			} else {
+				d.record(appendPath(path, key), ChangeRemoved, t[key], nil)
+			}
+		}
+		for _, key := range sortedKeys(other) {
+			if _, ok := t[key]; !ok {
+				d.record(appendPath(path, key), ChangeAdded, nil, other[key])
+			}
+		}
+		return
+	case []any:
+		other, ok := b.([]any)
+		if !ok {
+			break
+		}
+		if key, ok := d.identityKey(path); ok {
+			d.diffKeyedArray(path, key, t, other)
+			return
+		}
+		for idx := 0; idx < len(t) || idx < len(other); idx++ {
+			itemPath := appendPath(path, fmt.Sprint(idx))
+			switch {
+			case idx >= len(other):
+				d.record(itemPath, ChangeRemoved, t[idx], nil)
+			case idx >= len(t):
+				d.record(itemPath, ChangeAdded, nil, other[idx])
+			default:
+				d.diff(itemPath, t[idx], other[idx])
+			}
+		}
+		return
+	}
+
+	if typeName(a) != typeName(b) {
+		d.record(path, ChangeTypeChanged, a, b)
+		return
+	}
+	if numA, ok := asNumber(a); ok {
+		numB, _ := asNumber(b)
+		if math.Abs(numA-numB) > d.tolerance {
+			d.record(path, ChangeModified, a, b)
+		}
+		return
+	}
+	if !valuesEqual(a, b) {
+		d.record(path, ChangeModified, a, b)
+	}
+}
+
+// diffKeyedArray records the changes between arrays a and b, matching elements by the value of key.  Removed
+// elements are reported at their position within a, all others at their position within b.
+func (d *differ) diffKeyedArray(path []string, key string, a []any, b []any) {
+	identities := map[string]int{}
+	for idx, item := range a {
+		if id, ok := identity(item, key); ok {
+			identities[id] = idx
+		}
+	}
+
+	matched := map[int]bool{}
+	for idx, item := range b {
+		itemPath := appendPath(path, fmt.Sprint(idx))
+		id, ok := identity(item, key)
+		origIdx, found := identities[id]
+		if !ok || !found || matched[origIdx] {
+			d.record(itemPath, ChangeAdded, nil, item)
+			continue
+		}
+		matched[origIdx] = true
+		d.diff(itemPath, a[origIdx], item)
+	}
+
+	for idx, item := range a {
+		if !matched[idx] {
+			d.record(appendPath(path, fmt.Sprint(idx)), ChangeRemoved, item, nil)
+		}
+	}
+}
+
+// record appends a change
+func (d *differ) record(path []string, kind ChangeKind, oldVal any, newVal any) {
+	if d.ignored(path) {
+		return
+	}
+	d.changes = append(d.changes, Change{
+		Path: formatPointer(path...),
+		Keys: path,
+		Kind: kind,
+		Old:  oldVal,
+		New:  newVal,
+	})
+}
+
+// ignored returns true if path lies at or beneath an ignored path
+func (d *differ) ignored(path []string) bool {
+	for _, pattern := range d.ignore {
+		if len(pattern) <= len(path) && matchPath(pattern, path[:len(pattern)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// identityKey returns the identity key configured for the array at path
+func (d *differ) identityKey(path []string) (string, bool) {
+	for _, arrayKey := range d.arrayKeys {
+		if matchPath(arrayKey.pattern, path) {
+			return arrayKey.key, true
+		}
+	}
+	return "", false
+}
+
+// matchPath returns true if path matches pattern token for token, where a "*" token matches anything
+func matchPath(pattern []string, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for idx, token := range pattern {
+		if token != "*" && token != path[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// identity returns the textual identity of an array element, being the value at key
+func identity(item any, key string) (string, bool) {
+	obj, ok := item.(map[string]any)
+	if !ok {
+		return "", false
+	}
+	v, ok := obj[key]
+	if !ok {
+		return "", false
+	}
+	id, err := formatScalar(v)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s:%s", typeName(v), id), true
+}
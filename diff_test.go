@@ -0,0 +1,100 @@
+package keyval
+
+import (
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a, err := NewFromJson([]byte(`{"name": "app", "port": 80, "db": {"host": "h1", "pool": 4}, "tags": ["a", "b"], "mode": "1", "gone": true}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	b, err := NewFromYaml([]byte("name: app\nport: 81\ndb:\n  host: h2\n  pool: 4\n  tls: true\ntags: [a]\nmode: 1\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	changes := Diff(a, b)
+	expected := []struct {
+		path string
+		kind ChangeKind
+	}{
+		{"/db/host", ChangeModified},
+		{"/db/tls", ChangeAdded},
+		{"/gone", ChangeRemoved},
+		{"/mode", ChangeTypeChanged},
+		{"/port", ChangeModified},
+		{"/tags/1", ChangeRemoved},
+	}
+	if len(changes) != len(expected) {
+		t.Errorf("Expected %d changes, got %v", len(expected), changes)
+		return
+	}
+	for idx, change := range changes {
+		if change.Path != expected[idx].path || change.Kind != expected[idx].kind {
+			t.Errorf("Expected %s %s, got %s %s", expected[idx].kind, expected[idx].path, change.Kind, change.Path)
+			return
+		}
+	}
+	if changes[4].Old != 80.0 || changes[4].New != 81 {
+		t.Errorf("Unexpected old/new values %v/%v", changes[4].Old, changes[4].New)
+		return
+	}
+}
+
+func TestDiffNumericNormalization(t *testing.T) {
+	a, err := NewFromJson([]byte(`{"port": 80}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	b, err := NewFromYaml([]byte("port: 80\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	changes := Diff(a, b)
+	if len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v", changes)
+		return
+	}
+}
+
+func TestDiffOptions(t *testing.T) {
+	a, err := NewFromJson([]byte(`{"ratio": 0.5, "meta": {"updated": 1}, "servers": [{"name": "a", "weight": 1}, {"name": "b", "weight": 2}], "nodes": [{"meta": {"id": 1}}]}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	b, err := NewFromJson([]byte(`{"ratio": 0.5000001, "meta": {"updated": 2}, "servers": [{"name": "c", "weight": 3}, {"name": "b", "weight": 2}, {"name": "a", "weight": 5}], "nodes": [{"meta": {"id": 2}}]}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	changes := DiffWithOptions(a, b, DiffOptions{
+		IgnorePaths:    []string{"/meta", "/nodes/*/meta/id"},
+		FloatTolerance: 0.001,
+		ArrayKeys:      map[string]string{"/servers": "name"},
+	})
+	expected := []struct {
+		path string
+		kind ChangeKind
+	}{
+		{"/servers/0", ChangeAdded},
+		{"/servers/2/weight", ChangeModified},
+	}
+	if len(changes) != len(expected) {
+		t.Errorf("Expected %d changes, got %v", len(expected), changes)
+		return
+	}
+	for idx, change := range changes {
+		if change.Path != expected[idx].path || change.Kind != expected[idx].kind {
+			t.Errorf("Expected %s %s, got %s %s", expected[idx].kind, expected[idx].path, change.Kind, change.Path)
+			return
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package keyval
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode"
+)
+
+// initialisms are words rendered entirely in upper case when forming Go identifiers
+var initialisms = map[string]bool{
+	"API": true, "DB": true, "DNS": true, "HTTP": true, "HTTPS": true, "ID": true, "IP": true, "JSON": true,
+	"SQL": true, "SSH": true, "TCP": true, "TLS": true, "TTL": true, "UDP": true, "URI": true, "URL": true,
+	"UUID": true, "YAML": true,
+}
+
+// GenerateOptions controls the Go source produced by GenerateStructs
+type GenerateOptions struct {
+	// Package is the name of the generated package, "main" if empty
+	Package string
+	// TypeName is the name of the top level struct, "Config" if empty
+	TypeName string
+	// Generator names the tool responsible for the output within the generated code header
+	Generator string
+}
+
+// generator accumulates struct declarations
+type generator struct {
+	decls []string
+	names map[string]bool
+}
+
+// genField describes a single field of a generated struct
+type genField struct {
+	name     string
+	getter   string
+	key      string
+	goType   string
+	required bool
+}
+
+// GenerateStructs returns formatted Go source declaring structs which describe schema, with keyval, json and yaml
+// tags on every field, nil safe typed accessors for each field, and a Load function decoding a KeyVal into the top
+// level struct.  Accessors are named after their field with a "Get" prefix, and a numeric suffix should that name be
+// taken by another field.  An error is returned if a property's key contains a character which can't appear within a
+// struct tag: a quote, backquote, backslash or comma.  To generate code from sample documents, pass the result of
+// InferSchema.
+func GenerateStructs(schema *KeyVal, opts GenerateOptions) ([]byte, error) {
+	if opts.Package == "" {
+		opts.Package = "main"
+	}
+	if opts.TypeName == "" {
+		opts.TypeName = "Config"
+	}
+	if opts.Generator == "" {
+		opts.Generator = "keyval"
+	}
+
+	g := &generator{
+		names: map[string]bool{},
+	}
+	err := g.structType(opts.TypeName, schema.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by %s. DO NOT EDIT.\n\n", opts.Generator)
+	fmt.Fprintf(&buf, "package %s\n\n", opts.Package)
+	fmt.Fprintf(&buf, "import \"github.com/hashibuto/keyval\"\n\n")
+	fmt.Fprintf(&buf, "// Load%s decodes kv into a new %s\n", opts.TypeName, opts.TypeName)
+	fmt.Fprintf(&buf, "func Load%s(kv *keyval.KeyVal) (*%s, error) {\n", opts.TypeName, opts.TypeName)
+	fmt.Fprintf(&buf, "\tv := &%s{}\n\terr := kv.Decode(v)\n\tif err != nil {\n\t\treturn nil, err\n\t}\n\treturn v, nil\n}\n", opts.TypeName)
+	for _, decl := range g.decls {
+		buf.WriteString("\n")
+		buf.WriteString(decl)
+	}
+
+	return format.Source(buf.Bytes())
+}
+
+// structType declares a struct named name for the object schema, along with any nested types
+func (g *generator) structType(name string, schema map[string]any) error {
+	if g.names[name] {
+		return fmt.Errorf("Generated type name \"%s\" is not unique", name)
+	}
+	g.names[name] = true
+	// Reserve a position so that the struct is declared ahead of the nested types it refers to
+	pos := len(g.decls)
+	g.decls = append(g.decls, "")
+
+	properties, _ := schema["properties"].(map[string]any)
+	required := map[string]bool{}
+	if list, ok := schema["required"].([]any); ok {
+		for _, item := range list {
+			if key, ok := item.(string); ok {
+				required[key] = true
+			}
+		}
+	}
+
+	fields := []genField{}
+	fieldNames := map[string]bool{}
+	for _, key := range sortedKeys(properties) {
+		if strings.ContainsAny(key, "\"`\\,") {
+			return fmt.Errorf("Property %q of %s can't be used within a struct tag", key, name)
+		}
+		propSchema, _ := properties[key].(map[string]any)
+		fieldName := goIdentifier(key)
+		for suffix := 2; fieldNames[fieldName]; suffix++ {
+			fieldName = fmt.Sprintf("%s%d", goIdentifier(key), suffix)
+		}
+		fieldNames[fieldName] = true
+
+		goType, err := g.goType(name+fieldName, propSchema, required[key])
+		if err != nil {
+			return err
+		}
+		fields = append(fields, genField{
+			name:     fieldName,
+			key:      key,
+			goType:   goType,
+			required: required[key],
+		})
+	}
+
+	// Fields and methods share a namespace, so a getter may not take the name of another field, such as that of
+	// "get_name" alongside "name"
+	for idx := range fields {
+		getter := "Get" + fields[idx].name
+		for suffix := 2; fieldNames[getter]; suffix++ {
+			getter = fmt.Sprintf("Get%s%d", fields[idx].name, suffix)
+		}
+		fieldNames[getter] = true
+		fields[idx].getter = getter
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "// %s was generated from a schema\n", name)
+	fmt.Fprintf(&sb, "type %s struct {\n", name)
+	for _, field := range fields {
+		omit := ""
+		if !field.required {
+			omit = ",omitempty"
+		}
+		fmt.Fprintf(&sb, "\t%s %s `keyval:\"%s\" json:\"%s%s\" yaml:\"%s%s\"`\n", field.name, field.goType, field.key, field.key, omit, field.key, omit)
+	}
+	sb.WriteString("}\n")
+	for _, field := range fields {
+		fmt.Fprintf(&sb, "\n// %s returns %s, or its zero value if v is nil\n", field.getter, field.name)
+		fmt.Fprintf(&sb, "func (v *%s) %s() %s {\n", name, field.getter, field.goType)
+		fmt.Fprintf(&sb, "\tif v == nil {\n\t\tvar zero %s\n\t\treturn zero\n\t}\n\treturn v.%s\n}\n", field.goType, field.name)
+	}
+	g.decls[pos] = sb.String()
+
+	return nil
+}
+
+// goType returns the Go type representing schema, declaring a struct named name if one is required
+func (g *generator) goType(name string, schema map[string]any, required bool) (string, error) {
+	types := []string{}
+	switch t := schema["type"].(type) {
+	case string:
+		types = append(types, t)
+	case []any:
+		for _, item := range t {
+			if typeName, ok := item.(string); ok {
+				types = append(types, typeName)
+			}
+		}
+	}
+
+	withoutNull := removeString(types, "null")
+	nullable := len(withoutNull) != len(types)
+	types = withoutNull
+	if len(types) != 1 {
+		return "any", nil
+	}
+
+	goType := ""
+	switch types[0] {
+	case "string":
+		goType = "string"
+	case "integer":
+		goType = "int64"
+	case "number":
+		goType = "float64"
+	case "boolean":
+		goType = "bool"
+	case "array":
+		items, _ := schema["items"].(map[string]any)
+		itemType, err := g.goType(name+"Item", items, true)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + itemType, nil
+	case "object":
+		if _, ok := schema["properties"].(map[string]any); !ok {
+			return "map[string]any", nil
+		}
+		err := g.structType(name, schema)
+		if err != nil {
+			return "", err
+		}
+		if !required || nullable {
+			return "*" + name, nil
+		}
+		return name, nil
+	default:
+		return "any", nil
+	}
+
+	if nullable {
+		return "*" + goType, nil
+	}
+	return goType, nil
+}
+
+// goIdentifier returns an exported Go identifier formed from key
+func goIdentifier(key string) string {
+	words := strings.FieldsFunc(key, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var sb strings.Builder
+	for _, word := range words {
+		upper := strings.ToUpper(word)
+		if initialisms[upper] {
+			sb.WriteString(upper)
+			continue
+		}
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		sb.WriteString(string(runes))
+	}
+
+	ident := sb.String()
+	if ident == "" {
+		return "Field"
+	}
+	if unicode.IsDigit([]rune(ident)[0]) {
+		return "X" + ident
+	}
+	return ident
+}
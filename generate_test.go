@@ -0,0 +1,160 @@
+package keyval
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+func TestGenerateStructs(t *testing.T) {
+	sample, err := NewFromYaml([]byte("name: app\nserver-url: http://localhost\ndb:\n  host: localhost\n  port: 5432\nreplicas:\n  - host: r1\n    weight: 0.5\nenabled: true\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	src, err := GenerateStructs(InferSchema(sample), GenerateOptions{Package: "config"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = typeCheck(src)
+	if err != nil {
+		t.Errorf("Generated code did not compile: %v\n%s", err, string(src))
+		return
+	}
+
+	code := strings.Join(strings.Fields(string(src)), " ")
+	expected := []string{
+		"package config",
+		"func LoadConfig(kv *keyval.KeyVal) (*Config, error) {",
+		"type Config struct {",
+		"DB        ConfigDB             `keyval:\"db\" json:\"db\" yaml:\"db\"`",
+		"Replicas  []ConfigReplicasItem `keyval:\"replicas\" json:\"replicas\" yaml:\"replicas\"`",
+		"ServerURL string               `keyval:\"server-url\" json:\"server-url\" yaml:\"server-url\"`",
+		"type ConfigDB struct {",
+		"Port int64 `keyval:\"port\" json:\"port\" yaml:\"port\"`",
+		"Weight float64 `keyval:\"weight\" json:\"weight\" yaml:\"weight\"`",
+		"func (v *Config) GetEnabled() bool {",
+	}
+	for _, line := range expected {
+		if !strings.Contains(code, strings.Join(strings.Fields(line), " ")) {
+			t.Errorf("Expected generated code to contain:\n%s\nGot:\n%s", line, code)
+			return
+		}
+	}
+}
+
+func TestGenerateStructsNameCollisions(t *testing.T) {
+	schema, err := NewFromJson([]byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"get_name": {"type": "integer"}
+		}
+	}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	src, err := GenerateStructs(schema, GenerateOptions{Package: "config"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = typeCheck(src)
+	if err != nil {
+		t.Errorf("Generated code did not compile: %v\n%s", err, string(src))
+		return
+	}
+	code := strings.Join(strings.Fields(string(src)), " ")
+	for _, line := range []string{
+		"GetName int64 `keyval:\"get_name\"",
+		"func (v *Config) GetName2() string {",
+		"func (v *Config) GetGetName() int64 {",
+	} {
+		if !strings.Contains(code, line) {
+			t.Errorf("Expected generated code to contain:\n%s\nGot:\n%s", line, code)
+			return
+		}
+	}
+}
+
+func TestGenerateStructsInvalidKeys(t *testing.T) {
+	for _, key := range []string{`say "hi"`, "back`tick", `back\slash`, "a,b"} {
+		schema := NewFromMap(map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"db": map[string]any{
+					"type":       "object",
+					"properties": map[string]any{key: map[string]any{"type": "string"}},
+				},
+			},
+		})
+		_, err := GenerateStructs(schema, GenerateOptions{})
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%q", key)) {
+			t.Errorf("Expected an error naming %q, got %v", key, err)
+			return
+		}
+	}
+}
+
+// typeCheck type checks generated source against a stand-in for this package
+func typeCheck(src []byte) error {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "config_gen.go", src, 0)
+	if err != nil {
+		return err
+	}
+	conf := types.Config{
+		Importer: stubImporter{fset},
+	}
+	_, err = conf.Check("config", fset, []*ast.File{file}, nil)
+	return err
+}
+
+// stubImporter imports a stand-in for this package, declaring only what generated code uses
+type stubImporter struct {
+	fset *token.FileSet
+}
+
+func (i stubImporter) Import(path string) (*types.Package, error) {
+	if path != "github.com/hashibuto/keyval" {
+		return nil, fmt.Errorf("Unexpected import \"%s\"", path)
+	}
+	file, err := parser.ParseFile(i.fset, "keyval.go", "package keyval\n\ntype KeyVal struct{}\n\nfunc (kv *KeyVal) Decode(target any) error { return nil }\n", 0)
+	if err != nil {
+		return nil, err
+	}
+	return (&types.Config{}).Check(path, i.fset, []*ast.File{file}, nil)
+}
+
+func TestDecode(t *testing.T) {
+	kv, err := NewFromYaml([]byte("name: app\ndb:\n  port: 5432\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var target struct {
+		Name string `json:"name"`
+		DB   struct {
+			Port int64 `json:"port"`
+		} `json:"db"`
+	}
+	err = kv.Decode(&target)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if target.Name != "app" || target.DB.Port != 5432 {
+		t.Errorf("Unexpected decoded value %+v", target)
+		return
+	}
+}
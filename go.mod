@@ -2,4 +2,4 @@ module github.com/hashibuto/keyval
 
 go 1.19
 
-require gopkg.in/yaml.v3 v3.0.1 // indirect
+require gopkg.in/yaml.v3 v3.0.1
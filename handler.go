@@ -0,0 +1,460 @@
+package keyval
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultMaxBodySize is the largest request body accepted when a Handler doesn't specify a limit
+	DefaultMaxBodySize = 10 << 20
+
+	mediaJson       = "application/json"
+	mediaYaml       = "application/yaml"
+	mediaMergePatch = "application/merge-patch+json"
+	mediaJsonPatch  = "application/json-patch+json"
+)
+
+// yamlMediaTypes lists the media types accepted as YAML
+var yamlMediaTypes = map[string]bool{
+	mediaYaml:            true,
+	"application/x-yaml": true,
+	"text/yaml":          true,
+	"text/x-yaml":        true,
+}
+
+// Handler serves a SyncKeyVal over HTTP.  The request path selects a value by its keys, so "/db/host" refers to the
+// value at keys "db", "host" and "/" to the whole document.  Path segments are percent-decoded, allowing keys which
+// contain "/" to be addressed.  Use http.StripPrefix to serve the document beneath a prefix.  The supported methods
+// are:
+//
+//   - GET and HEAD return the value as JSON, or YAML if the Accept header prefers it
+//   - PUT sets the value from a JSON or YAML body, creating any missing parents
+//   - PATCH applies an RFC 7386 Merge Patch (application/merge-patch+json) or RFC 6902 JSON Patch
+//     (application/json-patch+json) to the value
+//   - DELETE removes the value
+//
+// Responses carry a strong ETag derived from the canonical form of the value (see Hash), and modifications honor
+// If-Match and If-None-Match, allowing clients to apply optimistic concurrency control.
+type Handler struct {
+	kv *SyncKeyVal
+	// ReadOnly rejects every modification with 405 Method Not Allowed
+	ReadOnly bool
+	// MaxBodySize is the largest request body accepted, DefaultMaxBodySize if zero
+	MaxBodySize int64
+}
+
+// httpError is an error carrying the HTTP status with which it should be reported
+type httpError struct {
+	status  int
+	message string
+}
+
+// Error returns the error message
+func (e *httpError) Error() string {
+	return e.message
+}
+
+// newHttpError returns an httpError with a formatted message
+func newHttpError(status int, format string, args ...any) *httpError {
+	return &httpError{
+		status:  status,
+		message: fmt.Sprintf(format, args...),
+	}
+}
+
+// NewHandler returns a new Handler serving kv
+func NewHandler(kv *SyncKeyVal) *Handler {
+	return &Handler{
+		kv: kv,
+	}
+}
+
+// ServeHTTP handles a single request
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	keys, err := requestKeys(r.URL)
+	if err == nil {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			err = h.serveGet(w, r, keys)
+		case http.MethodPut, http.MethodPatch, http.MethodDelete:
+			if h.ReadOnly {
+				err = h.methodNotAllowed(w)
+			} else {
+				err = h.serveModify(w, r, keys)
+			}
+		default:
+			err = h.methodNotAllowed(w)
+		}
+	}
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		var httpErr *httpError
+		if errors.As(err, &httpErr) {
+			status = httpErr.status
+		}
+		http.Error(w, err.Error(), status)
+	}
+}
+
+// serveGet writes the value at keys
+func (h *Handler) serveGet(w http.ResponseWriter, r *http.Request, keys []string) error {
+	asYaml := prefersYaml(r.Header.Get("Accept"))
+	var data []byte
+	var etag string
+	err := h.kv.View(func(kv *KeyVal) error {
+		v, exists, _ := lookupValue(kv, keys)
+		if !exists {
+			return newHttpError(http.StatusNotFound, "Not found")
+		}
+
+		var err error
+		etag, err = entityTag(v)
+		if err != nil {
+			return err
+		}
+		if asYaml {
+			data, err = yaml.Marshal(v)
+		} else {
+			data, err = json.Marshal(v)
+			data = append(data, '\n')
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept")
+	if matchesEntityTag(r.Header.Get("If-None-Match"), etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	if asYaml {
+		w.Header().Set("Content-Type", mediaYaml)
+	} else {
+		w.Header().Set("Content-Type", mediaJson)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+	return nil
+}
+
+// serveModify applies a PUT, PATCH or DELETE to the value at keys
+func (h *Handler) serveModify(w http.ResponseWriter, r *http.Request, keys []string) error {
+	// modify returns the new value given the current one, or removes the value if remove is true
+	var modify func(current any, exists bool) (result any, remove bool, err error)
+	switch r.Method {
+	case http.MethodPut:
+		value, err := h.readBody(w, r, mediaJson, mediaYaml)
+		if err != nil {
+			return err
+		}
+		modify = func(current any, exists bool) (any, bool, error) {
+			return value, false, nil
+		}
+	case http.MethodPatch:
+		mediaType := requestMediaType(r)
+		if mediaType == mediaJsonPatch {
+			data, err := h.readRaw(w, r)
+			if err != nil {
+				return err
+			}
+			ops, err := ParsePatch(data)
+			if err != nil {
+				return newHttpError(http.StatusBadRequest, "Invalid JSON Patch: %v", err)
+			}
+			modify = func(current any, exists bool) (any, bool, error) {
+				if !exists {
+					return nil, false, newHttpError(http.StatusNotFound, "Not found")
+				}
+				result, err := applyPatch(current, ops)
+				if err != nil {
+					return nil, false, newHttpError(http.StatusConflict, "%v", err)
+				}
+				return result, false, nil
+			}
+		} else {
+			patch, err := h.readBody(w, r, mediaMergePatch)
+			if err != nil {
+				return err
+			}
+			modify = func(current any, exists bool) (any, bool, error) {
+				return mergePatchValue(current, patch), false, nil
+			}
+		}
+	case http.MethodDelete:
+		if len(keys) == 0 {
+			return newHttpError(http.StatusMethodNotAllowed, "Cannot delete the document root")
+		}
+		modify = func(current any, exists bool) (any, bool, error) {
+			if !exists {
+				return nil, false, newHttpError(http.StatusNotFound, "Not found")
+			}
+			return nil, true, nil
+		}
+	}
+
+	var status int
+	var etag string
+	err := h.kv.Update(func(kv *KeyVal) error {
+		current, exists, blocked := lookupValue(kv, keys)
+		if blocked {
+			return newHttpError(http.StatusConflict, "A parent of the value is not a mapping")
+		}
+		var currentTag string
+		if exists {
+			var err error
+			currentTag, err = entityTag(current)
+			if err != nil {
+				return err
+			}
+		}
+		err := checkPreconditions(r, currentTag, exists)
+		if err != nil {
+			return err
+		}
+
+		result, remove, err := modify(deepCopy(current), exists)
+		if err != nil {
+			return err
+		}
+		if remove {
+			status = http.StatusNoContent
+			return kv.DeleteValue(keys...)
+		}
+
+		etag, err = entityTag(result)
+		if err != nil {
+			return newHttpError(http.StatusBadRequest, "%v", err)
+		}
+		status = http.StatusNoContent
+		if !exists {
+			status = http.StatusCreated
+		}
+		if len(keys) > 0 {
+			return kv.CreateValue(result, keys...)
+		}
+		root, ok := result.(map[string]any)
+		if !ok {
+			return newHttpError(http.StatusUnprocessableEntity, "The document root must be a mapping")
+		}
+		return kv.Replace(NewFromMap(root))
+	})
+	if err != nil {
+		return err
+	}
+
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.WriteHeader(status)
+	return nil
+}
+
+// methodNotAllowed responds with 405 Method Not Allowed, listing the allowed methods
+func (h *Handler) methodNotAllowed(w http.ResponseWriter) error {
+	allowed := "GET, HEAD"
+	if !h.ReadOnly {
+		allowed += ", PUT, PATCH, DELETE"
+	}
+	w.Header().Set("Allow", allowed)
+	return newHttpError(http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// readRaw reads the request body, up to the size limit
+func (h *Handler) readRaw(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	limit := h.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return nil, newHttpError(http.StatusRequestEntityTooLarge, "Request body exceeds %d bytes", limit)
+		}
+		return nil, newHttpError(http.StatusBadRequest, "%v", err)
+	}
+	return data, nil
+}
+
+// readBody reads and decodes the request body, which must be of one of the given media types.  JSON based media
+// types are decoded as JSON, mediaYaml admits any of the YAML media types.  A request without a Content-Type is
+// treated as the first of the given media types.
+func (h *Handler) readBody(w http.ResponseWriter, r *http.Request, mediaTypes ...string) (any, error) {
+	mediaType := requestMediaType(r)
+	if mediaType == "" {
+		mediaType = mediaTypes[0]
+	}
+	asYaml := yamlMediaTypes[mediaType]
+	supported := false
+	for _, candidate := range mediaTypes {
+		if candidate == mediaType || (candidate == mediaYaml && asYaml) {
+			supported = true
+		}
+	}
+	if !supported {
+		return nil, newHttpError(http.StatusUnsupportedMediaType, "Unsupported content type \"%s\"", mediaType)
+	}
+
+	data, err := h.readRaw(w, r)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	if asYaml {
+		err = yaml.Unmarshal(data, &value)
+		if err == nil {
+			// Normalize through JSON, so that YAML and JSON bodies produce identical values
+			data, err = json.Marshal(value)
+		}
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &value)
+	}
+	if err != nil {
+		return nil, newHttpError(http.StatusBadRequest, "Unable to parse request body: %v", err)
+	}
+	return value, nil
+}
+
+// requestMediaType returns the media type of the request body, or an empty string if none was given
+func requestMediaType(r *http.Request) string {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return mediaType
+}
+
+// requestKeys returns the keys addressed by the path of u, each segment being percent-decoded
+func requestKeys(u *url.URL) ([]string, error) {
+	path := strings.Trim(u.EscapedPath(), "/")
+	if path == "" {
+		return []string{}, nil
+	}
+
+	keys := strings.Split(path, "/")
+	for idx, segment := range keys {
+		key, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, newHttpError(http.StatusBadRequest, "Invalid path segment \"%s\"", segment)
+		}
+		keys[idx] = key
+	}
+	return keys, nil
+}
+
+// lookupValue returns the value at keys and whether it exists.  blocked is true if a parent of the value exists but
+// isn't a mapping.
+func lookupValue(kv *KeyVal, keys []string) (value any, exists bool, blocked bool) {
+	var obj any = kv.root
+	for _, key := range keys {
+		m, ok := obj.(map[string]any)
+		if !ok {
+			return nil, false, true
+		}
+		obj, ok = m[key]
+		if !ok {
+			return nil, false, false
+		}
+	}
+	return obj, true, false
+}
+
+// entityTag returns the strong entity tag of value
+func entityTag(value any) (string, error) {
+	hash, err := hashValue(value)
+	if err != nil {
+		return "", err
+	}
+	return `"` + hash + `"`, nil
+}
+
+// matchesEntityTag returns true if header, the value of an If-Match or If-None-Match header, matches etag.  Weak
+// entity tags only match when weak comparison is requested.
+func matchesEntityTag(header string, etag string, weak bool) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions evaluates the If-Match and If-None-Match headers of a modification against the current value
+func checkPreconditions(r *http.Request, etag string, exists bool) error {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !matchesEntityTag(ifMatch, etag, false) {
+		return newHttpError(http.StatusPreconditionFailed, "Precondition failed")
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && exists && matchesEntityTag(ifNoneMatch, etag, true) {
+		return newHttpError(http.StatusPreconditionFailed, "Precondition failed")
+	}
+	return nil
+}
+
+// prefersYaml returns true if an Accept header ranks YAML above JSON
+func prefersYaml(accept string) bool {
+	best := 0.0
+	asYaml := false
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		var isYaml bool
+		switch {
+		case yamlMediaTypes[mediaType]:
+			isYaml = true
+		case mediaType == mediaJson || mediaType == "application/*" || mediaType == "*/*":
+			isYaml = false
+		default:
+			continue
+		}
+		if q > best {
+			best = q
+			asYaml = isYaml
+		}
+	}
+	return asYaml
+}
@@ -0,0 +1,200 @@
+package keyval
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testRequest sends a request to handler, returning the response and its body
+func testRequest(handler http.Handler, method string, path string, body string, headers map[string]string) (*http.Response, string) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Result(), rec.Body.String()
+}
+
+// newTestHandler returns a Handler serving a small document
+func newTestHandler(t *testing.T) (*Handler, *SyncKeyVal) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "localhost", "port": 5432, "tags": ["a", "b"]}, "a/b": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	skv := NewSync(kv)
+	return NewHandler(skv), skv
+}
+
+func TestHandlerGet(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	tests := []struct {
+		path        string
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/db/host", "", http.StatusOK, mediaJson, "\"localhost\"\n"},
+		{"/db", "", http.StatusOK, mediaJson, "{\"host\":\"localhost\",\"port\":5432,\"tags\":[\"a\",\"b\"]}\n"},
+		{"/db/", "application/yaml", http.StatusOK, mediaYaml, "host: localhost\nport: 5432\ntags:\n    - a\n    - b\n"},
+		{"/db/tags", "application/json;q=0.5, text/yaml", http.StatusOK, mediaYaml, "- a\n- b\n"},
+		{"/a%2Fb", "", http.StatusOK, mediaJson, "1\n"},
+		{"/db/missing", "", http.StatusNotFound, "", ""},
+		{"/db/host/deeper", "", http.StatusNotFound, "", ""},
+	}
+
+	for _, test := range tests {
+		resp, body := testRequest(handler, http.MethodGet, test.path, "", map[string]string{"Accept": test.accept})
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: Expected status %d, got %d", test.path, test.status, resp.StatusCode)
+			return
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		if resp.Header.Get("Content-Type") != test.contentType || body != test.body {
+			t.Errorf("%s: Unexpected %s response %q", test.path, resp.Header.Get("Content-Type"), body)
+			return
+		}
+		if resp.Header.Get("ETag") == "" {
+			t.Errorf("%s: Expected an ETag", test.path)
+			return
+		}
+	}
+
+	// The ETag doesn't depend on the representation
+	resp, _ := testRequest(handler, http.MethodGet, "/db", "", nil)
+	etag := resp.Header.Get("ETag")
+	resp, _ = testRequest(handler, http.MethodGet, "/db", "", map[string]string{"Accept": mediaYaml})
+	if resp.Header.Get("ETag") != etag {
+		t.Errorf("Expected identical ETags for JSON and YAML")
+		return
+	}
+	resp, _ = testRequest(handler, http.MethodGet, "/db", "", map[string]string{"If-None-Match": etag})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected 304, got %d", resp.StatusCode)
+		return
+	}
+	resp, body := testRequest(handler, http.MethodHead, "/db", "", nil)
+	if resp.StatusCode != http.StatusOK || body != "" {
+		t.Errorf("Unexpected HEAD response %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestHandlerModify(t *testing.T) {
+	handler, skv := newTestHandler(t)
+	tests := []struct {
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{http.MethodPut, "/db/host", "", `"db.internal"`, http.StatusNoContent},
+		{http.MethodPut, "/cache/ttl", "application/yaml", "seconds: 60\n", http.StatusCreated},
+		{http.MethodPut, "/db/host/deeper", "", `1`, http.StatusConflict},
+		{http.MethodPut, "/db/host", "text/plain", `x`, http.StatusUnsupportedMediaType},
+		{http.MethodPut, "/db/host", "", `{invalid`, http.StatusBadRequest},
+		{http.MethodPatch, "/db", mediaMergePatch, `{"port": null, "tls": true}`, http.StatusNoContent},
+		{http.MethodPatch, "/db/tags", mediaJsonPatch, `[{"op": "add", "path": "/-", "value": "c"}]`, http.StatusNoContent},
+		{http.MethodPatch, "/db/tags", mediaJsonPatch, `[{"op": "test", "path": "/0", "value": "z"}]`, http.StatusConflict},
+		{http.MethodPatch, "/missing", mediaJsonPatch, `[]`, http.StatusNotFound},
+		{http.MethodDelete, "/a%2Fb", "", "", http.StatusNoContent},
+		{http.MethodDelete, "/a%2Fb", "", "", http.StatusNotFound},
+		{http.MethodDelete, "/", "", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/db", "", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		resp, body := testRequest(handler, test.method, test.path, test.body, map[string]string{"Content-Type": test.contentType})
+		if resp.StatusCode != test.status {
+			t.Errorf("%s %s: Expected status %d, got %d: %s", test.method, test.path, test.status, resp.StatusCode, body)
+			return
+		}
+	}
+
+	expectJson(t, skv.Snapshot(), `{"cache":{"ttl":{"seconds":60}},"db":{"host":"db.internal","tags":["a","b","c"],"tls":true}}`)
+
+	// Replacing the root requires a mapping
+	resp, _ := testRequest(handler, http.MethodPut, "/", `[1]`, nil)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422, got %d", resp.StatusCode)
+		return
+	}
+	resp, _ = testRequest(handler, http.MethodPut, "/", `{"x": 1}`, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", resp.StatusCode)
+		return
+	}
+	expectJson(t, skv.Snapshot(), `{"x":1}`)
+
+	handler.ReadOnly = true
+	resp, _ = testRequest(handler, http.MethodPut, "/x", `2`, nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, HEAD" {
+		t.Errorf("Expected a read only handler to reject modifications, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerConditional(t *testing.T) {
+	handler, skv := newTestHandler(t)
+	resp, _ := testRequest(handler, http.MethodGet, "/db/port", "", nil)
+	etag := resp.Header.Get("ETag")
+
+	resp, _ = testRequest(handler, http.MethodPut, "/db/port", `1`, map[string]string{"If-Match": etag})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", resp.StatusCode)
+		return
+	}
+	newTag := resp.Header.Get("ETag")
+	if newTag == "" || newTag == etag {
+		t.Errorf("Expected a new ETag, got %s", newTag)
+		return
+	}
+
+	// The stale ETag no longer matches
+	resp, _ = testRequest(handler, http.MethodPut, "/db/port", `2`, map[string]string{"If-Match": etag})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412, got %d", resp.StatusCode)
+		return
+	}
+	resp, _ = testRequest(handler, http.MethodDelete, "/db/port", "", map[string]string{"If-Match": etag})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412, got %d", resp.StatusCode)
+		return
+	}
+	resp, _ = testRequest(handler, http.MethodPatch, "/db", `{"port": 3}`, map[string]string{"If-Match": `W/` + newTag})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected weak ETags not to match If-Match, got %d", resp.StatusCode)
+		return
+	}
+
+	// If-None-Match: * only creates
+	resp, _ = testRequest(handler, http.MethodPut, "/db/port", `4`, map[string]string{"If-None-Match": "*"})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412, got %d", resp.StatusCode)
+		return
+	}
+	resp, _ = testRequest(handler, http.MethodPut, "/db/user", `"admin"`, map[string]string{"If-None-Match": "*"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected 201, got %d", resp.StatusCode)
+		return
+	}
+	resp, _ = testRequest(handler, http.MethodPut, "/db/password", `"x"`, map[string]string{"If-Match": "*"})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412, got %d", resp.StatusCode)
+		return
+	}
+
+	port, err := skv.Number("db", "port")
+	if err != nil || port != 1 {
+		t.Errorf("Expected port 1, got %v", port)
+	}
+}
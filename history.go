@@ -0,0 +1,334 @@
+package keyval
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// DefaultHistoryLimit is the number of modifications retained when EnableHistory is called without a limit
+const DefaultHistoryLimit = 100
+
+var (
+	// ErrHistoryDisabled is returned when taking a snapshot of a KeyVal whose history isn't enabled
+	ErrHistoryDisabled = errors.New("History is not enabled")
+	// ErrNothingToUndo is returned by Undo when there is no modification to undo
+	ErrNothingToUndo = errors.New("Nothing to undo")
+	// ErrNothingToRedo is returned by Redo when there is no undone modification to redo
+	ErrNothingToRedo = errors.New("Nothing to redo")
+)
+
+// history records the modifications made to a KeyVal so that they may be undone
+type history struct {
+	limit int
+	undo  []historyEntry
+	redo  []historyEntry
+	// base identifies the oldest state which can be returned to, being the initial state until entries are evicted
+	base      uint64
+	nextId    uint64
+	snapshots map[string]uint64
+	replaying bool
+}
+
+// historyEntry is a single modification, identified by the state it produced
+type historyEntry struct {
+	id  uint64
+	ops []historyOp
+}
+
+// historyOp records the value at keys before and after a modification, either of which may be absent.  Undoing the
+// operation restores old, redoing it restores new.
+type historyOp struct {
+	keys []string
+	old  mergeValue
+	new  mergeValue
+}
+
+// EnableHistory starts recording modifications so that they may be undone, retaining the most recent limit of them
+// (DefaultHistoryLimit if limit isn't positive).  Each modification (SetValue, CreateValue, DeleteValue, MergePatch,
+// Replace, FillDefaults, or a committed transaction) is one step, stored as the values it replaced rather than a copy
+// of the document.  Calling EnableHistory again changes the limit, keeping the existing history.
+func (kv *KeyVal) EnableHistory(limit int) {
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	if kv.history == nil {
+		kv.history = &history{
+			snapshots: map[string]uint64{},
+		}
+	}
+	kv.history.limit = limit
+	kv.history.trim()
+}
+
+// DisableHistory stops recording modifications, discarding the history and any snapshots
+func (kv *KeyVal) DisableHistory() {
+	kv.history = nil
+}
+
+// CanUndo returns true if there is a modification which Undo would revert
+func (kv *KeyVal) CanUndo() bool {
+	return kv.history != nil && len(kv.history.undo) > 0
+}
+
+// CanRedo returns true if there is an undone modification which Redo would reapply
+func (kv *KeyVal) CanRedo() bool {
+	return kv.history != nil && len(kv.history.redo) > 0
+}
+
+// Undo reverts the most recent modification
+func (kv *KeyVal) Undo() error {
+	if kv.frozen {
+		return ErrFrozen
+	}
+	if !kv.CanUndo() {
+		return ErrNothingToUndo
+	}
+	h := kv.history
+	entry := h.undo[len(h.undo)-1]
+	err := kv.replay(entry, false)
+	if err != nil {
+		return err
+	}
+	h.undo = h.undo[:len(h.undo)-1]
+	h.redo = append(h.redo, entry)
+	return nil
+}
+
+// Redo reapplies the most recently undone modification.  Any new modification discards the modifications which
+// could be redone.
+func (kv *KeyVal) Redo() error {
+	if kv.frozen {
+		return ErrFrozen
+	}
+	if !kv.CanRedo() {
+		return ErrNothingToRedo
+	}
+	h := kv.history
+	entry := h.redo[len(h.redo)-1]
+	err := kv.replay(entry, true)
+	if err != nil {
+		return err
+	}
+	h.redo = h.redo[:len(h.redo)-1]
+	h.undo = append(h.undo, entry)
+	return nil
+}
+
+// Snapshot names the current state of the document so that it may be returned to with RestoreSnapshot.  A snapshot
+// costs nothing beyond the history itself, however it can only be restored while the history still reaches it.
+func (kv *KeyVal) Snapshot(name string) error {
+	if kv.history == nil {
+		return ErrHistoryDisabled
+	}
+	kv.history.snapshots[name] = kv.history.state()
+	return nil
+}
+
+// RestoreSnapshot returns the document to the named snapshot by undoing or redoing modifications, so that Undo and
+// Redo continue from that point.  An error is returned if the snapshot doesn't exist, or is no longer reachable
+// because the modifications leading to it were evicted from the history or discarded by a modification made after
+// an Undo.
+func (kv *KeyVal) RestoreSnapshot(name string) error {
+	if kv.history == nil {
+		return ErrHistoryDisabled
+	}
+	h := kv.history
+	id, ok := h.snapshots[name]
+	if !ok {
+		return fmt.Errorf("Snapshot \"%s\" does not exist", name)
+	}
+
+	step := kv.Undo
+	if h.reachable(h.redo, id) {
+		step = kv.Redo
+	} else if id != h.base && !h.reachable(h.undo, id) {
+		return fmt.Errorf("Snapshot \"%s\" is no longer reachable through the history", name)
+	}
+	for h.state() != id {
+		err := step()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record performs the modification fn, which affects the value at keys (nil for the whole document), adding it to
+// the history.  A modification of the whole document may declare the mappings it modifies in place with within, a
+// tree whose mappings mark the positions of those mappings, every other value being replaced rather than modified.
+// Only those mappings are copied before a KeyVal which isn't persistent is modified, or the whole document if within
+// is nil.
+func (kv *KeyVal) record(keys []string, within map[string]any, fn func() error) error {
+	h := kv.history
+	if h == nil || h.replaying {
+		return fn()
+	}
+
+	var ops []historyOp
+	if keys == nil {
+		var before map[string]any
+		if kv.owned != nil {
+			// Relinquishing ownership leaves the current tree untouched by the modification
+			before = kv.root
+			kv.setOwnership(&ownership{})
+		} else if within != nil {
+			before = copyWithin(kv.root, within)
+		} else {
+			before = deepCopy(kv.root).(map[string]any)
+		}
+		err := fn()
+		if err != nil {
+			return err
+		}
+		ops = diffOps(nil, mergeValue{before, true}, mergeValue{kv.root, true}, []historyOp{})
+	} else {
+		keys = affectedKeys(kv.root, keys)
+		v, err := kv.lookup(keys...)
+		old := mergeValue{deepCopy(v), err == nil}
+		err = fn()
+		if err != nil {
+			return err
+		}
+		v, err = kv.lookup(keys...)
+		new := mergeValue{deepCopy(v), err == nil}
+		if !sameMergeValue(old, new) {
+			ops = []historyOp{{keys, old, new}}
+		}
+	}
+
+	if len(ops) > 0 {
+		h.nextId++
+		h.undo = append(h.undo, historyEntry{
+			id:  h.nextId,
+			ops: ops,
+		})
+		h.redo = nil
+		h.trim()
+	}
+	return nil
+}
+
+// replay undoes or redoes entry
+func (kv *KeyVal) replay(entry historyEntry, redo bool) error {
+	kv.history.replaying = true
+	defer func() {
+		kv.history.replaying = false
+	}()
+
+	return kv.notify(nil, func() error {
+		for idx := range entry.ops {
+			if redo {
+				op := entry.ops[idx]
+				kv.restore(op.keys, op.new)
+			} else {
+				op := entry.ops[len(entry.ops)-1-idx]
+				kv.restore(op.keys, op.old)
+			}
+		}
+		return nil
+	})
+}
+
+// restore sets the value at keys to a copy of v, removing it if v is absent
+func (kv *KeyVal) restore(keys []string, v mergeValue) {
+	parent, owned, _ := kv.walk(true, keys[:len(keys)-1]...)
+	key := keys[len(keys)-1]
+	if !v.present {
+		delete(parent, key)
+		owned.drop(key)
+		return
+	}
+	parent[key] = deepCopy(v.value)
+	owned.claim(key)
+}
+
+// state returns the identifier of the current state
+func (h *history) state() uint64 {
+	if len(h.undo) > 0 {
+		return h.undo[len(h.undo)-1].id
+	}
+	return h.base
+}
+
+// reachable returns true if entries contains the entry producing state id
+func (h *history) reachable(entries []historyEntry, id uint64) bool {
+	for _, entry := range entries {
+		if entry.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// trim evicts the oldest entries beyond the limit
+func (h *history) trim() {
+	for len(h.undo) > h.limit {
+		h.base = h.undo[0].id
+		h.undo = h.undo[1:]
+	}
+}
+
+// affectedKeys returns the keys of the outermost value which may be changed by a modification at keys, which is the
+// first mapping that would be created along the way if any are missing
+func affectedKeys(root map[string]any, keys []string) []string {
+	obj := root
+	for idx := 0; idx < len(keys)-1; idx++ {
+		child, ok := obj[keys[idx]].(map[string]any)
+		if !ok {
+			return keys[:idx+1]
+		}
+		obj = child
+	}
+	return keys
+}
+
+// copyWithin returns a copy of obj in which the mappings at the positions of the mappings within are copied, so that
+// they may be modified in place without affecting the copy.  Every other value is shared with obj.
+func copyWithin(obj map[string]any, within map[string]any) map[string]any {
+	result := make(map[string]any, len(obj))
+	for key, val := range obj {
+		result[key] = val
+	}
+	for key, val := range within {
+		withinChild, ok := val.(map[string]any)
+		child, isMapping := obj[key].(map[string]any)
+		if ok && isMapping {
+			result[key] = copyWithin(child, withinChild)
+		}
+	}
+	return result
+}
+
+// diffOps appends an operation for every value which differs between a and b, both located at path, descending into
+// mappings present on both sides.  A mapping present on both sides is unchanged, and isn't compared, since mappings
+// are only modified in place once copied.
+func diffOps(path []string, a mergeValue, b mergeValue, ops []historyOp) []historyOp {
+	mapA, okA := a.value.(map[string]any)
+	mapB, okB := b.value.(map[string]any)
+	if !okA || !okB {
+		if sameMergeValue(a, b) {
+			return ops
+		}
+		return append(ops, historyOp{
+			keys: path,
+			old:  copyMergeValue(a),
+			new:  copyMergeValue(b),
+		})
+	}
+	if reflect.ValueOf(mapA).Pointer() == reflect.ValueOf(mapB).Pointer() {
+		return ops
+	}
+
+	keys := map[string]any{}
+	for key := range mapA {
+		keys[key] = true
+	}
+	for key := range mapB {
+		keys[key] = true
+	}
+	for _, key := range sortedKeys(keys) {
+		ops = diffOps(appendPath(path, key), lookupMergeValue(mapA, key), lookupMergeValue(mapB, key), ops)
+	}
+	return ops
+}
@@ -0,0 +1,229 @@
+package keyval
+
+import (
+	"testing"
+)
+
+func TestUndoRedo(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "h1", "port": 5432}, "name": "app"}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testUndoRedo(t, kv.Copy())
+	testUndoRedo(t, kv.Persistent())
+}
+
+// testUndoRedo makes a series of modifications, then undoes and redoes every one of them
+func testUndoRedo(t *testing.T, kv *KeyVal) {
+	kv.EnableHistory(0)
+	if kv.CanUndo() || kv.Undo() != ErrNothingToUndo {
+		t.Errorf("Expected nothing to undo")
+		return
+	}
+
+	patch, err := NewFromJson([]byte(`{"db": {"port": null, "tls": true}, "name": "other"}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defaults, err := NewFromJson([]byte(`{"db": {"port": 5432, "tls": false}, "log": {"level": "info"}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	replacement, err := NewFromJson([]byte(`{"db": {"host": "h5"}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	states := []string{`{"db":{"host":"h1","port":5432},"name":"app"}`}
+	steps := []func() error{
+		func() error { return kv.SetValue("h2", "db", "host") },
+		func() error { return kv.CreateValue(60, "cache", "ttl", "seconds") },
+		func() error { return kv.DeleteValue("name") },
+		func() error { return kv.MergePatch(patch) },
+		func() error {
+			return kv.Transaction(func(tx *KeyVal) error {
+				tx.DeleteValue("cache")
+				return tx.SetValue("h3", "db", "host")
+			})
+		},
+		func() error {
+			_, err := kv.FillDefaults(defaults)
+			return err
+		},
+		func() error { return kv.Replace(replacement) },
+		func() error { return kv.MergePatch(patch) },
+	}
+	for _, step := range steps {
+		err := step()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		data, err := kv.ToJson()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		states = append(states, string(data))
+	}
+
+	// Setting an identical value isn't a step
+	err = kv.SetValue("other", "name")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for idx := len(states) - 2; idx >= 0; idx-- {
+		err := kv.Undo()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		expectJson(t, kv, states[idx])
+	}
+	if kv.Undo() != ErrNothingToUndo {
+		t.Errorf("Expected nothing to undo")
+		return
+	}
+
+	for idx := 1; idx < len(states); idx++ {
+		err := kv.Redo()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		expectJson(t, kv, states[idx])
+	}
+	if kv.Redo() != ErrNothingToRedo {
+		t.Errorf("Expected nothing to redo")
+		return
+	}
+
+	// A new modification discards the redo history
+	kv.Undo()
+	kv.SetValue("h4", "db", "host")
+	if kv.CanRedo() {
+		t.Errorf("Expected nothing to redo")
+		return
+	}
+	kv.Undo()
+	expectJson(t, kv, states[len(states)-2])
+}
+
+func TestHistoryInPlace(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "h1", "tls": {"enabled": false}}, "name": "app"}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	kv.EnableHistory(0)
+	sub, err := kv.GetKeyVal("db")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	patch, err := NewFromJson([]byte(`{"db": {"tls": {"enabled": true}}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Recording a modification of the whole document still modifies it in place, so sub-KeyVals see it
+	err = kv.MergePatch(patch)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, sub, `{"host":"h1","tls":{"enabled":true}}`)
+	err = kv.Undo()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, kv, `{"db":{"host":"h1","tls":{"enabled":false}},"name":"app"}`)
+	if len(kv.history.redo) != 1 || len(kv.history.redo[0].ops) != 1 {
+		t.Errorf("Expected a single operation, got %v", kv.history.redo)
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	kv := New()
+	kv.EnableHistory(2)
+	for i := 1; i <= 4; i++ {
+		kv.SetValue(i, "count")
+	}
+
+	kv.Undo()
+	kv.Undo()
+	expectJson(t, kv, `{"count":2}`)
+	if kv.Undo() != ErrNothingToUndo {
+		t.Errorf("Expected the history to be limited to 2 steps")
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	kv := New()
+	if kv.Snapshot("start") != ErrHistoryDisabled {
+		t.Errorf("Expected ErrHistoryDisabled")
+		return
+	}
+	kv.EnableHistory(3)
+	changes := 0
+	kv.OnChange("", func(oldVal any, newVal any) {
+		changes++
+	})
+
+	kv.Snapshot("empty")
+	kv.SetValue(1, "a")
+	kv.SetValue(2, "b")
+	kv.Snapshot("ab")
+	kv.SetValue(3, "c")
+
+	err := kv.RestoreSnapshot("empty")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, kv, `{}`)
+	err = kv.RestoreSnapshot("ab")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, kv, `{"a":1,"b":2}`)
+	if changes != 8 {
+		t.Errorf("Expected 8 change notifications, got %d", changes)
+		return
+	}
+
+	// Redo continues from the restored snapshot
+	kv.Redo()
+	expectJson(t, kv, `{"a":1,"b":2,"c":3}`)
+
+	if kv.RestoreSnapshot("missing") == nil {
+		t.Errorf("Expected an error restoring a missing snapshot")
+		return
+	}
+	kv.SetValue(4, "d")
+	if kv.RestoreSnapshot("empty") == nil {
+		t.Errorf("Expected an error restoring an evicted snapshot")
+		return
+	}
+	expectJson(t, kv, `{"a":1,"b":2,"c":3,"d":4}`)
+}
+
+func BenchmarkMergePatchHistory(b *testing.B) {
+	kv := benchmarkDocument(100)
+	kv.EnableHistory(0)
+	patch := New()
+	patch.CreateValue("changed", "section50", "key50")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kv.MergePatch(patch)
+		kv.Undo()
+	}
+}
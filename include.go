@@ -0,0 +1,238 @@
+package keyval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultIncludeDepth is the maximum include nesting depth used when a Loader doesn't specify one
+	DefaultIncludeDepth = 32
+
+	includeTag = "!include"
+	refKey     = "$ref"
+)
+
+// Loader loads JSON and YAML documents from a filesystem, pulling in other files referenced by an "!include" YAML
+// tag or a {"$ref": "other.json#/path"} object.  References are resolved relative to the file containing them, and
+// may carry a JSON pointer fragment selecting a portion of the referenced document.  References consisting only of
+// a fragment (eg. "#/definitions/thing") are left untouched.  Only the references within the selected portion of a
+// document are followed, so two files may include portions of each other, provided no portion ends up including
+// itself.
+type Loader struct {
+	FS       fs.FS
+	MaxDepth int
+}
+
+// NewFromFile returns a new KeyVal instance from a JSON or YAML file within fsys, resolving any includes.  Files
+// ending in ".json" are parsed as JSON, anything else is parsed as YAML.
+func NewFromFile(fsys fs.FS, name string) (*KeyVal, error) {
+	loader := &Loader{
+		FS: fsys,
+	}
+	return loader.Load(name)
+}
+
+// Load returns a new KeyVal instance from the named file, resolving any includes
+func (l *Loader) Load(name string) (*KeyVal, error) {
+	v, err := l.load(path.Clean(name), "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := v.(type) {
+	case nil:
+		return New(), nil
+	case map[string]any:
+		return NewFromMap(t), nil
+	default:
+		return nil, fmt.Errorf("Document root of \"%s\" was not a mapping", name)
+	}
+}
+
+// load parses the named file, returning the value located at pointer.  stack holds the references being loaded, a
+// reference to a portion of a file being its name followed by the pointer fragment.
+func (l *Loader) load(name string, pointer string, stack []string) (any, error) {
+	maxDepth := l.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultIncludeDepth
+	}
+	if len(stack) >= maxDepth {
+		return nil, fmt.Errorf("Include depth limit of %d exceeded loading \"%s\"", maxDepth, name)
+	}
+	ref := name
+	if pointer != "" {
+		ref += "#" + pointer
+	}
+	for _, loading := range stack {
+		if loading == ref {
+			return nil, fmt.Errorf("Include cycle detected: %s -> %s", strings.Join(stack, " -> "), ref)
+		}
+	}
+	stack = append(stack, ref)
+
+	data, err := fs.ReadFile(l.FS, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc any
+	if strings.ToLower(path.Ext(name)) == ".json" {
+		if len(data) > 0 {
+			err = json.Unmarshal(data, &doc)
+		}
+	} else {
+		doc, err = l.parseYaml(name, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse \"%s\": %v", name, err)
+	}
+
+	// Follow the pointer before resolving references, so that only those within the selected value are followed.  A
+	// reference met along the way is followed with the remainder of the pointer.
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	v := doc
+	for idx := range tokens {
+		if ref, ok := reference(v); ok {
+			if !strings.Contains(ref, "#") {
+				ref += "#"
+			}
+			return l.include(name, ref+formatPointer(tokens[idx:]...), stack)
+		}
+		v, err = pointerValue(v, tokens[idx:idx+1])
+		if err != nil {
+			return nil, fmt.Errorf("Unable to resolve \"%s#%s\": %v", name, pointer, err)
+		}
+	}
+
+	return l.resolveRefs(name, v, stack)
+}
+
+// reference returns the file reference made by v, if it is a {"$ref": "file#pointer"} object
+func reference(v any) (string, bool) {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return "", false
+	}
+	ref, ok := obj[refKey].(string)
+	if !ok || strings.HasPrefix(ref, "#") {
+		return "", false
+	}
+	return ref, true
+}
+
+// include loads the value referred to by ref, relative to the file named by name
+func (l *Loader) include(name string, ref string, stack []string) (any, error) {
+	file, pointer, _ := strings.Cut(ref, "#")
+	if file == "" {
+		return nil, fmt.Errorf("Reference \"%s\" in \"%s\" does not name a file", ref, name)
+	}
+
+	v, err := l.load(path.Join(path.Dir(name), file), pointer, stack)
+	if err != nil || strings.ToLower(path.Ext(name)) == ".json" {
+		return v, err
+	}
+
+	// Values included into a YAML document take the types YAML would give them, such as integers rather than the
+	// floats of JSON
+	var node yaml.Node
+	err = node.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	var normalized any
+	err = node.Decode(&normalized)
+	if err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// parseYaml parses YAML data, replacing any nodes tagged with "!include" by the equivalent $ref object
+func (l *Loader) parseYaml(name string, data []byte) (any, error) {
+	var node yaml.Node
+	err := yaml.Unmarshal(data, &node)
+	if err != nil {
+		return nil, err
+	}
+	if node.Kind == 0 {
+		return nil, nil
+	}
+
+	err = l.expandIncludeTags(name, &node)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc any
+	err = node.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// expandIncludeTags replaces every "!include" tagged node beneath node with a $ref object, which is resolved along
+// with any others
+func (l *Loader) expandIncludeTags(name string, node *yaml.Node) error {
+	if node.Tag == includeTag {
+		if node.Kind != yaml.ScalarNode {
+			return fmt.Errorf("The %s tag in \"%s\" must be applied to a file name", includeTag, name)
+		}
+		if node.Value == "" || strings.HasPrefix(node.Value, "#") {
+			return fmt.Errorf("Reference \"%s\" in \"%s\" does not name a file", node.Value, name)
+		}
+		*node = yaml.Node{
+			Kind: yaml.MappingNode,
+			Tag:  "!!map",
+			Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Tag: "!!str", Value: refKey},
+				{Kind: yaml.ScalarNode, Tag: "!!str", Value: node.Value},
+			},
+		}
+		return nil
+	}
+
+	for _, child := range node.Content {
+		err := l.expandIncludeTags(name, child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveRefs replaces every {"$ref": "file#pointer"} object within obj with the content it refers to
+func (l *Loader) resolveRefs(name string, obj any, stack []string) (any, error) {
+	switch t := obj.(type) {
+	case map[string]any:
+		if ref, ok := reference(t); ok {
+			return l.include(name, ref, stack)
+		}
+		for key, val := range t {
+			resolved, err := l.resolveRefs(name, val, stack)
+			if err != nil {
+				return nil, err
+			}
+			t[key] = resolved
+		}
+	case []any:
+		for idx, val := range t {
+			resolved, err := l.resolveRefs(name, val, stack)
+			if err != nil {
+				return nil, err
+			}
+			t[idx] = resolved
+		}
+	}
+
+	return obj, nil
+}
@@ -0,0 +1,171 @@
+package keyval
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIncludeYaml(t *testing.T) {
+	fsys := fstest.MapFS{
+		"config/main.yaml":        {Data: []byte("name: app\ndb: !include parts/db.yaml\nport: !include parts/ports.json#/http\n")},
+		"config/parts/db.yaml":    {Data: []byte("host: localhost\nuser: !include user.yaml\n")},
+		"config/parts/user.yaml":  {Data: []byte("admin\n")},
+		"config/parts/ports.json": {Data: []byte(`{"http": 8080, "https": 8443}`)},
+	}
+
+	kv, err := NewFromFile(fsys, "config/main.yaml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	host, err := kv.String("db", "host")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if host != "localhost" {
+		t.Errorf("Expected localhost, got %s", host)
+		return
+	}
+
+	user, err := kv.String("db", "user")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if user != "admin" {
+		t.Errorf("Expected admin, got %s", user)
+		return
+	}
+
+	port, err := kv.Value("port")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if port != 8080 {
+		t.Errorf("Expected 8080, got %v", port)
+		return
+	}
+}
+
+func TestIncludeJsonRef(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.json":          {Data: []byte(`{"servers": [{"$ref": "shared/web.json"}], "limits": {"$ref": "shared/web.json#/limits"}, "local": {"$ref": "#/servers"}}`)},
+		"shared/web.json":    {Data: []byte(`{"host": "web1", "limits": {"$ref": "limits.yaml"}}`)},
+		"shared/limits.yaml": {Data: []byte("cpu: 2\n")},
+	}
+
+	kv, err := NewFromFile(fsys, "main.json")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	servers, err := kv.Array("servers")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	host := servers[0].(map[string]any)["host"]
+	if host != "web1" {
+		t.Errorf("Expected web1, got %v", host)
+		return
+	}
+
+	cpu, err := kv.Value("limits", "cpu")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if cpu != 2 {
+		t.Errorf("Expected 2, got %v", cpu)
+		return
+	}
+
+	ref, err := kv.String("local", "$ref")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if ref != "#/servers" {
+		t.Errorf("Expected local reference to be left untouched, got %s", ref)
+		return
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.yaml": {Data: []byte("b: !include b.yaml\n")},
+		"b.yaml": {Data: []byte("a: !include a.yaml\n")},
+	}
+
+	_, err := NewFromFile(fsys, "a.yaml")
+	if err == nil {
+		t.Errorf("Expected include cycle to be detected")
+		return
+	}
+}
+
+func TestIncludePortions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.yaml": {Data: []byte("x: !include b.yaml#/x\ny: 1\nz: !include b.yaml#/w/v\n")},
+		"b.yaml": {Data: []byte("x: {y: !include a.yaml#/y}\nw: !include c.yaml\n")},
+		"c.yaml": {Data: []byte("v: 2\n")},
+	}
+
+	// Files may include portions of each other which don't lead back to themselves, and pointers may pass through
+	// an include
+	kv, err := NewFromFile(fsys, "a.yaml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, kv, `{"x":{"y":1},"y":1,"z":2}`)
+
+	fsys["a.yaml"] = &fstest.MapFile{Data: []byte("x: !include b.yaml#/x\ny: !include b.yaml#/x\n")}
+	_, err = NewFromFile(fsys, "a.yaml")
+	if err == nil || !strings.Contains(err.Error(), "b.yaml#/x -> a.yaml#/y -> b.yaml#/x") {
+		t.Errorf("Expected include cycle to be detected, got %v", err)
+		return
+	}
+}
+
+func TestIncludeDepth(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.yaml": {Data: []byte("b: !include b.yaml\n")},
+		"b.yaml": {Data: []byte("c: !include c.yaml\n")},
+		"c.yaml": {Data: []byte("d: 1\n")},
+	}
+
+	loader := &Loader{
+		FS:       fsys,
+		MaxDepth: 2,
+	}
+	_, err := loader.Load("a.yaml")
+	if err == nil {
+		t.Errorf("Expected include depth limit to be exceeded")
+		return
+	}
+
+	loader.MaxDepth = 3
+	_, err = loader.Load("a.yaml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestIncludeMissingFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.yaml": {Data: []byte("b: !include missing.yaml\n")},
+	}
+
+	_, err := NewFromFile(fsys, "a.yaml")
+	if err == nil {
+		t.Errorf("Expected missing include to fail")
+		return
+	}
+}
@@ -0,0 +1,161 @@
+package keyval
+
+import (
+	"sort"
+)
+
+const (
+	// maxEnumValues is the largest number of distinct strings for which InferSchema will produce an enum
+	maxEnumValues = 5
+	// minEnumObservations is the fewest string observations required before InferSchema will produce an enum
+	minEnumObservations = 3
+)
+
+// schemaNode accumulates observations of the values found at a single location across the samples
+type schemaNode struct {
+	types      map[string]bool
+	strings    map[string]bool
+	stringObs  int
+	objects    int
+	properties map[string]*schemaNode
+	keyCounts  map[string]int
+	items      *schemaNode
+}
+
+// InferSchema returns a JSON schema describing the supplied sample documents.  The types observed at each location
+// are unioned, object keys present in every sample of that object are marked as required, and strings which take
+// on only a handful of distinct values across enough observations are described with an enum.
+func InferSchema(samples ...*KeyVal) *KeyVal {
+	node := newSchemaNode()
+	for _, sample := range samples {
+		node.observe(sample.root)
+	}
+
+	schema := node.schema()
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	return NewFromMap(schema)
+}
+
+// newSchemaNode returns an empty schemaNode
+func newSchemaNode() *schemaNode {
+	return &schemaNode{
+		types:      map[string]bool{},
+		strings:    map[string]bool{},
+		properties: map[string]*schemaNode{},
+		keyCounts:  map[string]int{},
+	}
+}
+
+// observe records a single value
+func (n *schemaNode) observe(value any) {
+	name := typeName(value)
+	if name == "number" {
+		if integer, _ := typeMatches("integer", value); integer {
+			name = "integer"
+		}
+	}
+	n.types[name] = true
+
+	switch t := value.(type) {
+	case string:
+		n.stringObs++
+		if len(n.strings) <= maxEnumValues {
+			n.strings[t] = true
+		}
+	case map[string]any:
+		n.objects++
+		for key, val := range t {
+			prop, ok := n.properties[key]
+			if !ok {
+				prop = newSchemaNode()
+				n.properties[key] = prop
+			}
+			prop.observe(val)
+			n.keyCounts[key]++
+		}
+	case []any:
+		if n.items == nil {
+			n.items = newSchemaNode()
+		}
+		for _, item := range t {
+			n.items.observe(item)
+		}
+	}
+}
+
+// schema returns the JSON schema describing every observation
+func (n *schemaNode) schema() map[string]any {
+	schema := map[string]any{}
+
+	types := []string{}
+	for name := range n.types {
+		types = append(types, name)
+	}
+	// An integer is also a number, so only the wider type need be listed
+	if n.types["integer"] && n.types["number"] {
+		types = removeString(types, "integer")
+	}
+	sort.Strings(types)
+	switch len(types) {
+	case 0:
+	case 1:
+		schema["type"] = types[0]
+	default:
+		typeList := make([]any, len(types))
+		for idx, name := range types {
+			typeList[idx] = name
+		}
+		schema["type"] = typeList
+	}
+
+	if n.types["string"] && len(types) == 1 && n.stringObs >= minEnumObservations &&
+		len(n.strings) <= maxEnumValues && len(n.strings) < n.stringObs {
+		values := []string{}
+		for value := range n.strings {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		enum := make([]any, len(values))
+		for idx, value := range values {
+			enum[idx] = value
+		}
+		schema["enum"] = enum
+	}
+
+	if n.objects > 0 {
+		properties := map[string]any{}
+		required := []string{}
+		for key, prop := range n.properties {
+			properties[key] = prop.schema()
+			if n.keyCounts[key] == n.objects {
+				required = append(required, key)
+			}
+		}
+		schema["properties"] = properties
+		if len(required) > 0 {
+			sort.Strings(required)
+			requiredList := make([]any, len(required))
+			for idx, key := range required {
+				requiredList[idx] = key
+			}
+			schema["required"] = requiredList
+		}
+	}
+
+	if n.items != nil && len(n.items.types) > 0 {
+		schema["items"] = n.items.schema()
+	}
+
+	return schema
+}
+
+// removeString returns items with every occurrence of value removed
+func removeString(items []string, value string) []string {
+	result := []string{}
+	for _, item := range items {
+		if item != value {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,54 @@
+package keyval
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestInferSchema(t *testing.T) {
+	sampleA, err := NewFromJson([]byte(`{"name": "a", "port": 80, "mode": "dev", "tags": ["x"], "db": {"host": "h1"}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sampleB, err := NewFromYaml([]byte("name: b\nport: 8080.5\nmode: prod\ndb:\n  host: h2\n  pool: 4\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sampleC, err := NewFromJson([]byte(`{"name": "c", "port": "auto", "mode": "dev", "db": {"host": "h3"}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	schema := InferSchema(sampleA, sampleB, sampleC)
+	data, err := json.Marshal(schema.root)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := `{"$schema":"https://json-schema.org/draft/2020-12/schema","properties":{` +
+		`"db":{"properties":{"host":{"type":"string"},"pool":{"type":"integer"}},"required":["host"],"type":"object"},` +
+		`"mode":{"enum":["dev","prod"],"type":"string"},` +
+		`"name":{"type":"string"},` +
+		`"port":{"type":["number","string"]},` +
+		`"tags":{"items":{"type":"string"},"type":"array"}},` +
+		`"required":["db","mode","name","port"],"type":"object"}`
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s\n", expected, string(data))
+		return
+	}
+
+	for _, sample := range []*KeyVal{sampleA, sampleB, sampleC} {
+		violations, err := sample.Validate(schema)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(violations) != 0 {
+			t.Errorf("Expected sample to validate, got %v", violations)
+			return
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package keyval
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Interpolation expressions take the following forms within string values:
+//
+//	${some.key}            value of another key within the document (keys split on ".")
+//	${env:NAME}            value of the environment variable NAME
+//	${some.key:-fallback}  fallback text used when the key (or environment variable) is missing
+//	$${literal}            escaped, produces the literal text ${literal}
+//
+// A reference to a key which is missing from the document falls back to the environment variable of the same name,
+// so ${NAME:-default} expands to the document's NAME, failing that the environment's NAME, failing that "default".
+//
+// A string consisting of nothing but a single key reference takes on the type of the referenced value, so
+// "${server.port}" resolves to a number if server.port is a number.
+const (
+	interpolateOpen    = "${"
+	interpolateEscape  = "$${"
+	interpolateClose   = "}"
+	interpolateEnv     = "env:"
+	interpolateDefault = ":-"
+)
+
+// segment is a piece of a parsed string, either literal text or an interpolation expression
+type segment struct {
+	text   string
+	isExpr bool
+}
+
+// resolver expands interpolation expressions against a document, decrypting any secrets they refer to
+type resolver struct {
+	kv     *KeyVal
+	active []string
+}
+
+// Resolve returns a new KeyVal with all interpolation expressions within string values expanded.  Secrets which
+// aren't referred to by an expression remain encrypted.
+func (kv *KeyVal) Resolve() (*KeyVal, error) {
+	r := &resolver{kv: kv}
+	root, err := r.resolve(kv.root)
+	if err != nil {
+		return nil, err
+	}
+
+	return kv.inherit(&KeyVal{
+		root: root.(map[string]any),
+	}), nil
+}
+
+// ResolveValue returns a value with all interpolation expressions expanded, or an error if the value cannot be
+// located or resolved
+func (kv *KeyVal) ResolveValue(keys ...string) (any, error) {
+	v, err := kv.Value(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &resolver{kv: kv}
+	if len(keys) > 0 {
+		r.active = append(r.active, strings.Join(keys, "."))
+	}
+	return r.resolve(v)
+}
+
+// ResolveString returns a string with all interpolation expressions expanded, or an error if the data can't be
+// found, resolved, or properly cast
+func (kv *KeyVal) ResolveString(keys ...string) (string, error) {
+	v, err := kv.ResolveValue(keys...)
+	if err != nil {
+		return "", err
+	}
+
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	default:
+		return "", fmt.Errorf("Value was not a string")
+	}
+}
+
+// resolve returns a copy of value with all nested strings interpolated
+func (r *resolver) resolve(value any) (any, error) {
+	switch t := value.(type) {
+	case string:
+		return r.resolveString(t)
+	case map[string]any:
+		target := map[string]any{}
+		for key, val := range t {
+			resolved, err := r.resolve(val)
+			if err != nil {
+				return nil, err
+			}
+			target[key] = resolved
+		}
+		return target, nil
+	case []any:
+		target := make([]any, len(t))
+		for idx, val := range t {
+			resolved, err := r.resolve(val)
+			if err != nil {
+				return nil, err
+			}
+			target[idx] = resolved
+		}
+		return target, nil
+	default:
+		return value, nil
+	}
+}
+
+// resolveString expands every expression within str
+func (r *resolver) resolveString(str string) (any, error) {
+	segments, err := parseSegments(str)
+	if err != nil {
+		return nil, err
+	}
+
+	// A lone expression keeps the type of whatever it refers to
+	if len(segments) == 1 && segments[0].isExpr {
+		return r.evaluate(segments[0].text)
+	}
+
+	var sb strings.Builder
+	for _, seg := range segments {
+		if !seg.isExpr {
+			sb.WriteString(seg.text)
+			continue
+		}
+		v, err := r.evaluate(seg.text)
+		if err != nil {
+			return nil, err
+		}
+		text, err := formatScalar(v)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to interpolate \"%s\": %v", seg.text, err)
+		}
+		sb.WriteString(text)
+	}
+
+	return sb.String(), nil
+}
+
+// evaluate returns the value of a single expression (without the surrounding delimiters)
+func (r *resolver) evaluate(expr string) (any, error) {
+	fallback := ""
+	hasFallback := false
+	if idx := strings.Index(expr, interpolateDefault); idx >= 0 {
+		fallback = expr[idx+len(interpolateDefault):]
+		expr = expr[:idx]
+		hasFallback = true
+	}
+
+	if strings.HasPrefix(expr, interpolateEnv) {
+		name := expr[len(interpolateEnv):]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			if hasFallback {
+				return fallback, nil
+			}
+			return nil, fmt.Errorf("Environment variable \"%s\" is not set", name)
+		}
+		return v, nil
+	}
+
+	if expr == "" {
+		return nil, fmt.Errorf("Empty interpolation expression")
+	}
+
+	for _, path := range r.active {
+		if path == expr {
+			return nil, fmt.Errorf("Interpolation cycle detected: %s -> %s", strings.Join(r.active, " -> "), expr)
+		}
+	}
+
+	v, err := r.kv.Value(SplitKey(expr)...)
+	if err != nil {
+		if env, ok := os.LookupEnv(expr); ok {
+			return env, nil
+		}
+		if hasFallback {
+			return fallback, nil
+		}
+		return nil, fmt.Errorf("Unable to resolve reference \"%s\": %v", expr, err)
+	}
+
+	r.active = append(r.active, expr)
+	defer func() {
+		r.active = r.active[:len(r.active)-1]
+	}()
+
+	return r.resolve(v)
+}
+
+// parseSegments splits str into literal text and expressions, unescaping any escaped expressions
+func parseSegments(str string) ([]segment, error) {
+	segments := []segment{}
+	var literal strings.Builder
+	for len(str) > 0 {
+		if strings.HasPrefix(str, interpolateEscape) {
+			literal.WriteString(interpolateOpen)
+			str = str[len(interpolateEscape):]
+			continue
+		}
+		if strings.HasPrefix(str, interpolateOpen) {
+			end := strings.Index(str, interpolateClose)
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated interpolation expression in \"%s\"", str)
+			}
+			if literal.Len() > 0 {
+				segments = append(segments, segment{text: literal.String()})
+				literal.Reset()
+			}
+			segments = append(segments, segment{text: str[len(interpolateOpen):end], isExpr: true})
+			str = str[end+len(interpolateClose):]
+			continue
+		}
+		literal.WriteByte(str[0])
+		str = str[1:]
+	}
+	if literal.Len() > 0 || len(segments) == 0 {
+		segments = append(segments, segment{text: literal.String()})
+	}
+
+	return segments, nil
+}
+
+// formatScalar returns the textual form of a scalar value for embedding within a string
+func formatScalar(value any) (string, error) {
+	switch t := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return t, nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case int, int64, int32, uint, uint64, uint32, float32:
+		return fmt.Sprint(t), nil
+	default:
+		return "", fmt.Errorf("Value is not a scalar")
+	}
+}
@@ -0,0 +1,193 @@
+package keyval
+
+import (
+	"testing"
+)
+
+func TestResolveReference(t *testing.T) {
+	source := []byte(`{"db": {"host": "localhost", "port": 5432}, "url": "postgres://${db.host}:${db.port}/app", "port": "${db.port}"}`)
+	kv, err := NewFromJson(source)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	resolved, err := kv.Resolve()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	url, err := resolved.String("url")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if url != "postgres://localhost:5432/app" {
+		t.Errorf("Expected postgres://localhost:5432/app, got %s", url)
+		return
+	}
+
+	port, err := resolved.Number("port")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if port != 5432 {
+		t.Errorf("Expected 5432, got %v", port)
+		return
+	}
+
+	orig, err := kv.String("url")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if orig != "postgres://${db.host}:${db.port}/app" {
+		t.Errorf("Original document was modified")
+		return
+	}
+}
+
+func TestResolveEnv(t *testing.T) {
+	t.Setenv("KEYVAL_TEST_HOST", "example.com")
+	t.Setenv("KEYVAL_TEST_PORT", "8080")
+	t.Setenv("KEYVAL_TEST_USER", "admin")
+	source := []byte(`{
+		"host": "${env:KEYVAL_TEST_HOST}",
+		"user": "${env:KEYVAL_TEST_MISSING:-nobody}",
+		"name": "${missing.key:-anon}",
+		"port": "${KEYVAL_TEST_PORT:-80}",
+		"owner": "${KEYVAL_TEST_USER}",
+		"KEYVAL_TEST_USER": "root",
+		"url": "http://${KEYVAL_TEST_MISSING:-localhost}:${KEYVAL_TEST_PORT}"
+	}`)
+	kv, err := NewFromJson(source)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	host, err := kv.ResolveString("host")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if host != "example.com" {
+		t.Errorf("Expected example.com, got %s", host)
+		return
+	}
+
+	user, err := kv.ResolveString("user")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if user != "nobody" {
+		t.Errorf("Expected nobody, got %s", user)
+		return
+	}
+
+	name, err := kv.ResolveString("name")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if name != "anon" {
+		t.Errorf("Expected anon, got %s", name)
+		return
+	}
+
+	// References missing from the document fall back to the environment, then to the default
+	for key, expected := range map[string]string{
+		"port":  "8080",
+		"owner": "root",
+		"url":   "http://localhost:8080",
+	} {
+		v, err := kv.ResolveString(key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if v != expected {
+			t.Errorf("Expected %s to resolve to %s, got %s", key, expected, v)
+			return
+		}
+	}
+}
+
+func TestResolveChained(t *testing.T) {
+	source := []byte(`{"a": "${b}/a", "b": "${c}/b", "c": "root"}`)
+	kv, err := NewFromJson(source)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	v, err := kv.ResolveString("a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if v != "root/b/a" {
+		t.Errorf("Expected root/b/a, got %s", v)
+		return
+	}
+}
+
+func TestResolveCycle(t *testing.T) {
+	source := []byte(`{"a": "${b}", "b": "x${c}", "c": "${a}"}`)
+	kv, err := NewFromJson(source)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = kv.Resolve()
+	if err == nil {
+		t.Errorf("Expected cycle to be detected")
+		return
+	}
+
+	_, err = kv.ResolveValue("a")
+	if err == nil {
+		t.Errorf("Expected cycle to be detected")
+		return
+	}
+}
+
+func TestResolveEscape(t *testing.T) {
+	source := []byte(`{"a": "$${not.a.ref} and ${b}", "b": "value"}`)
+	kv, err := NewFromJson(source)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	v, err := kv.ResolveString("a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if v != "${not.a.ref} and value" {
+		t.Errorf("Expected ${not.a.ref} and value, got %s", v)
+		return
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	source := []byte(`{"a": "${missing}", "b": "${unterminated", "c": "x${d}", "d": {"e": 1}}`)
+	kv, err := NewFromJson(source)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		_, err = kv.ResolveValue(key)
+		if err == nil {
+			t.Errorf("Expected error resolving %s", key)
+			return
+		}
+	}
+}
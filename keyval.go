@@ -2,14 +2,39 @@ package keyval
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
+	"sync/atomic"
 
 	"gopkg.in/yaml.v3"
 )
 
+// ErrFrozen is returned when attempting to modify a frozen KeyVal, such as one loaded from a Store
+var ErrFrozen = errors.New("KeyVal is frozen")
+
 type KeyVal struct {
-	root map[string]any
+	root   map[string]any
+	frozen bool
+	// subscriptions holds the callbacks registered with OnChange, nil if there are none
+	subscriptions *subscriptions
+	// owned records the mappings which a persistent KeyVal may modify in place, nil if the KeyVal isn't persistent
+	owned *ownership
+	// generation counts the times a persistent KeyVal's tree was shared, and ownedGeneration is the generation at
+	// which owned was recorded
+	generation      *atomic.Uint64
+	ownedGeneration uint64
+	// revision counts the modifications made to the KeyVal
+	revision uint64
+	// view locates the KeyVal within the one it was taken from by GetKeyVal, nil unless that KeyVal isn't persistent
+	view *view
+	// versions records the revision at which each modified path last changed, nil until the first modification
+	versions *versionNode
+	// history records modifications for Undo and Redo, nil if history isn't enabled
+	history *history
+	// keyProvider supplies the keys used to decrypt secrets, nil if secrets aren't decrypted
+	keyProvider KeyProvider
 }
 
 // New returns an empty KeyVal instance
@@ -61,21 +86,39 @@ func NewFromMap(data map[string]any) *KeyVal {
 	}
 }
 
-// GetKeyVal returns a new KeyVal object at the nested key position.
+// GetKeyVal returns a new KeyVal object at the nested key position.  Unless the KeyVal is persistent, the two share
+// data, and a modification made through the new KeyVal counts as a modification of this one, advancing its versions
+// and causing any transaction begun on it to conflict.
 func (kv *KeyVal) GetKeyVal(keys ...string) (*KeyVal, error) {
-	v, err := kv.Value(keys...)
+	v, err := kv.lookup(keys...)
 	if err != nil {
 		return nil, err
 	}
 
 	switch t := v.(type) {
 	case map[string]any:
-		return NewFromMap(t), nil
+		if kv.owned != nil {
+			return kv.share(t, kv.frozen), nil
+		}
+		sub := kv.inherit(NewFromMap(t))
+		sub.frozen = kv.frozen
+		sub.view = &view{
+			parent: kv,
+			keys:   append([]string{}, keys...),
+		}
+		return sub, nil
 	default:
 		return nil, fmt.Errorf("Data at key was not a generic map")
 	}
 }
 
+// view is the location of a KeyVal returned by GetKeyVal within a KeyVal which isn't persistent.  The two share
+// data, so modifications made through the view are also counted as modifications of the parent.
+type view struct {
+	parent *KeyVal
+	keys   []string
+}
+
 // SplitKey splits a multi-part key string into its separate components.  The default delimiter is "."
 func SplitKey(key string, delim ...string) []string {
 	delimStr := "."
@@ -100,18 +143,22 @@ func (kv *KeyVal) SetValue(value any, keys ...string) error {
 		v = value
 	}
 
-	switch len(keys) {
-	case 0:
-	case 1:
-		kv.root[keys[0]] = v
-	default:
-		target, err := walk(kv.root, false, keys[:len(keys)-1]...)
-		if err != nil {
-			return err
-		}
-		target[keys[len(keys)-1]] = v
+	if kv.frozen {
+		return ErrFrozen
 	}
-	return nil
+	return kv.notify(keys, func() error {
+		switch len(keys) {
+		case 0:
+		default:
+			target, owned, err := kv.walk(false, keys[:len(keys)-1]...)
+			if err != nil {
+				return err
+			}
+			target[keys[len(keys)-1]] = v
+			owned.disown(keys[len(keys)-1])
+		}
+		return nil
+	})
 }
 
 // CreateValue sets a nested value within the object.  If a parent key cannot be located, it is created.
@@ -129,22 +176,71 @@ func (kv *KeyVal) CreateValue(value any, keys ...string) error {
 		v = value
 	}
 
-	switch len(keys) {
-	case 0:
-	case 1:
-		kv.root[keys[0]] = v
-	default:
-		target, err := walk(kv.root, true, keys[:len(keys)-1]...)
+	if kv.frozen {
+		return ErrFrozen
+	}
+	return kv.notify(keys, func() error {
+		switch len(keys) {
+		case 0:
+		default:
+			target, owned, err := kv.walk(true, keys[:len(keys)-1]...)
+			if err != nil {
+				return err
+			}
+			target[keys[len(keys)-1]] = v
+			owned.disown(keys[len(keys)-1])
+		}
+		return nil
+	})
+}
+
+// DeleteValue removes a nested value from the object.  If the value cannot be located, an error is returned.
+func (kv *KeyVal) DeleteValue(keys ...string) error {
+	if kv.frozen {
+		return ErrFrozen
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("Cannot delete the document root")
+	}
+	return kv.notify(keys, func() error {
+		target, owned, err := kv.walk(false, keys[:len(keys)-1]...)
 		if err != nil {
 			return err
 		}
-		target[keys[len(keys)-1]] = v
+		_, ok := target[keys[len(keys)-1]]
+		if !ok {
+			return fmt.Errorf("Could not resolve value using key")
+		}
+		delete(target, keys[len(keys)-1])
+		owned.drop(keys[len(keys)-1])
+		return nil
+	})
+}
+
+// Replace replaces the entire content of the object with a copy of other's, for instance the result of Stack
+func (kv *KeyVal) Replace(other *KeyVal) error {
+	if kv.frozen {
+		return ErrFrozen
 	}
-	return nil
+	return kv.notifyDocument(map[string]any{}, func() error {
+		kv.root = deepCopy(other.root).(map[string]any)
+		kv.setOwnership(fullOwnership())
+		return nil
+	})
 }
 
-// Value returns a value or an error if the value cannot be located
+// Value returns a value or an error if the value cannot be located.  If a key provider is configured, secrets within
+// the value are decrypted.
 func (kv *KeyVal) Value(keys ...string) (any, error) {
+	v, err := kv.lookup(keys...)
+	if err != nil {
+		return nil, err
+	}
+	return kv.reveal(keys, v)
+}
+
+// lookup returns the stored value at keys, without decrypting secrets
+func (kv *KeyVal) lookup(keys ...string) (any, error) {
 	var obj any = kv.root
 	var ok bool
 	for _, key := range keys {
@@ -207,7 +303,8 @@ func (kv *KeyVal) Boolean(keys ...string) (bool, error) {
 	}
 }
 
-// Array returns an array or an error if the data can't be found, or properly cast
+// Array returns an array or an error if the data can't be found, or properly cast.  The array is the KeyVal's own,
+// unless a key provider is configured, in which case it is a copy with any secrets decrypted.
 func (kv *KeyVal) Array(keys ...string) ([]any, error) {
 	v, err := kv.Value(keys...)
 	if err != nil {
@@ -222,7 +319,8 @@ func (kv *KeyVal) Array(keys ...string) ([]any, error) {
 	}
 }
 
-// Mapping returns an array or an error if the data can't be found, or properly cast
+// Mapping returns a mapping or an error if the data can't be found, or properly cast.  The mapping is the KeyVal's
+// own, unless a key provider is configured, in which case it is a copy with any secrets decrypted.
 func (kv *KeyVal) Mapping(keys ...string) (map[string]any, error) {
 	v, err := kv.Value(keys...)
 	if err != nil {
@@ -237,32 +335,84 @@ func (kv *KeyVal) Mapping(keys ...string) (map[string]any, error) {
 	}
 }
 
-// Copy returns a deep copy of KeyVal
+// Copy returns a deep copy of KeyVal.  Copying a persistent KeyVal shares its structure and takes constant time.
 func (kv *KeyVal) Copy() *KeyVal {
-	return &KeyVal{
-		root: deepCopy(kv.root).(map[string]any),
+	if kv.owned != nil {
+		return kv.share(kv.root, false)
 	}
+	return kv.inherit(&KeyVal{
+		root: deepCopy(kv.root).(map[string]any),
+	})
 }
 
 // Stack creates a new KeyVal object with the current instance being the base, and layer being stacked atop
 func (kv *KeyVal) Stack(layer *KeyVal) *KeyVal {
+	if kv.owned != nil {
+		return kv.stackPersistent(layer)
+	}
 	base := deepCopy(kv.root).(map[string]any)
 	topLayer := deepCopy(layer.root).(map[string]any)
 
 	stack(base, topLayer)
-	return &KeyVal{
+	return kv.inherit(&KeyVal{
 		root: base,
-	}
+	})
+}
+
+// inherit carries kv's key provider over to derived, a KeyVal holding data derived from kv's, returning derived
+func (kv *KeyVal) inherit(derived *KeyVal) *KeyVal {
+	derived.keyProvider = kv.keyProvider
+	return derived
+}
+
+// MarshalOptions governs ToJsonWithOptions and ToYamlWithOptions
+type MarshalOptions struct {
+	// Redact masks sensitive values, as Redacted does
+	Redact bool
+	// RedactionRules are the rules applied when Redact is set, DefaultRedactionRules if empty
+	RedactionRules []string
 }
 
 // ToJson marshals the entire data structure to a JSON byte array
 func (kv *KeyVal) ToJson() ([]byte, error) {
-	return json.Marshal(kv.root)
+	return kv.ToJsonWithOptions(MarshalOptions{})
+}
+
+// ToJsonWithOptions marshals the entire data structure to a JSON byte array, as governed by opts
+func (kv *KeyVal) ToJsonWithOptions(opts MarshalOptions) ([]byte, error) {
+	return json.Marshal(kv.marshalRoot(opts))
 }
 
 // ToYaml marshals the entire data structure to a YAML byte array
 func (kv *KeyVal) ToYaml() ([]byte, error) {
-	return yaml.Marshal(kv.root)
+	return kv.ToYamlWithOptions(MarshalOptions{})
+}
+
+// ToYamlWithOptions marshals the entire data structure to a YAML byte array, as governed by opts
+func (kv *KeyVal) ToYamlWithOptions(opts MarshalOptions) ([]byte, error) {
+	return yaml.Marshal(kv.marshalRoot(opts))
+}
+
+// marshalRoot returns the data structure to be marshaled according to opts
+func (kv *KeyVal) marshalRoot(opts MarshalOptions) map[string]any {
+	if opts.Redact {
+		return kv.Redacted(opts.RedactionRules...).root
+	}
+	return kv.root
+}
+
+// Decode unmarshals the entire data structure into target, which is populated according to its json struct tags.
+// If a key provider is configured, secrets are decrypted.
+func (kv *KeyVal) Decode(target any) error {
+	root, err := kv.reveal(nil, kv.root)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(root)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
 }
 
 // deepCopy returns a deep copy of obj
@@ -321,37 +471,95 @@ func stack(layerA map[string]any, layerB map[string]any) {
 	}
 }
 
-// walk walks a path through the object, arriving at the final target or returning an error
-func walk(obj map[string]any, fill bool, keys ...string) (map[string]any, error) {
-	var pos any = obj
+// walk walks a path through the object, arriving at the final target or returning an error.  When the KeyVal is
+// persistent, each shared mapping along the path (including the root) is copied so that it may be safely modified,
+// and the target's ownership is returned so that the caller may record what it places within the target.
+func (kv *KeyVal) walk(fill bool, keys ...string) (map[string]any, *ownership, error) {
+	owned := kv.ownership()
+	kv.root = kv.own(owned, kv.root)
+	pos := kv.root
 	for _, key := range keys {
-		switch t := pos.(type) {
-		case map[string]any:
-			target, ok := t[key]
-			if ok {
-				pos = target
-				switch pos.(type) {
-				case map[string]any:
-				default:
-					if fill {
-						t[key] = map[string]any{}
-						pos = t[key]
-					} else {
-						return nil, fmt.Errorf("Key was not reachable")
-					}
-				}
-			} else {
-				if fill {
-					t[key] = map[string]any{}
-					pos = t[key]
-				} else {
-					return nil, fmt.Errorf("Key missing during object traversal")
-				}
+		child := owned.child(key)
+		target, ok := pos[key]
+		if !ok {
+			if !fill {
+				return nil, nil, fmt.Errorf("Key missing during object traversal")
 			}
-		default:
-			return nil, fmt.Errorf("Object at key was incorrect type")
+			target = kv.newMapping(child)
 		}
+
+		next, ok := target.(map[string]any)
+		if !ok {
+			if !fill {
+				return nil, nil, fmt.Errorf("Key was not reachable")
+			}
+			next = kv.newMapping(child)
+		}
+		next = kv.own(child, next)
+		pos[key] = next
+		pos = next
+		owned = child
+	}
+
+	return pos, owned, nil
+}
+
+// asNumber returns value as a float64 if it is any of the numeric types produced by the JSON or YAML decoders
+func asNumber(value any) (float64, bool) {
+	switch t := value.(type) {
+	case float64:
+		return t, true
+	case float32:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case int32:
+		return float64(t), true
+	case uint:
+		return float64(t), true
+	case uint64:
+		return float64(t), true
+	case uint32:
+		return float64(t), true
+	default:
+		return 0, false
 	}
+}
 
-	return pos.(map[string]any), nil
+// valuesEqual returns true if a and b are deeply equal, treating all numeric types as interchangeable
+func valuesEqual(a any, b any) bool {
+	if numA, ok := asNumber(a); ok {
+		numB, ok := asNumber(b)
+		return ok && numA == numB
+	}
+
+	switch t := a.(type) {
+	case map[string]any:
+		other, ok := b.(map[string]any)
+		if !ok || len(t) != len(other) {
+			return false
+		}
+		for key, val := range t {
+			otherVal, ok := other[key]
+			if !ok || !valuesEqual(val, otherVal) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		other, ok := b.([]any)
+		if !ok || len(t) != len(other) {
+			return false
+		}
+		for idx, val := range t {
+			if !valuesEqual(val, other[idx]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b)
+	}
 }
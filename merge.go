@@ -0,0 +1,132 @@
+package keyval
+
+import (
+	"fmt"
+)
+
+// Conflict describes a location at which both sides of a three-way merge made incompatible changes
+type Conflict struct {
+	// Path is a JSON pointer to the conflicting value, the empty string refers to the document root
+	Path string
+	// Keys holds the unescaped components of Path
+	Keys   []string
+	Base   any
+	Ours   any
+	Theirs any
+	// InBase, InOurs and InTheirs are false where the value is absent from the respective document
+	InBase   bool
+	InOurs   bool
+	InTheirs bool
+}
+
+// Error returns the conflict formatted as a string
+func (c Conflict) Error() string {
+	path := c.Path
+	if path == "" {
+		path = "(root)"
+	}
+	return fmt.Sprintf("%s: Conflicting changes (base %s, ours %s, theirs %s)", path,
+		describeMergeValue(c.Base, c.InBase), describeMergeValue(c.Ours, c.InOurs), describeMergeValue(c.Theirs, c.InTheirs))
+}
+
+// mergeValue is a value taking part in a three-way merge, which may be absent
+type mergeValue struct {
+	value   any
+	present bool
+}
+
+// Merge3 performs a three-way merge, applying the changes made to base by both ours and theirs.  Changes made by only
+// one side, or made identically by both, are applied.  Where both sides changed the same value differently the value
+// from ours is kept and a Conflict is reported.  Mappings are merged key by key, while arrays and scalars are treated
+// as single values.  None of the supplied documents are modified.
+func Merge3(base *KeyVal, ours *KeyVal, theirs *KeyVal) (*KeyVal, []Conflict) {
+	conflicts := []Conflict{}
+	merged := merge3(nil,
+		mergeValue{base.root, true},
+		mergeValue{ours.root, true},
+		mergeValue{theirs.root, true},
+		&conflicts,
+	)
+
+	root, ok := merged.value.(map[string]any)
+	if !ok {
+		root = map[string]any{}
+	}
+	return NewFromMap(root), conflicts
+}
+
+// merge3 returns the merged value at path
+func merge3(path []string, base mergeValue, ours mergeValue, theirs mergeValue, conflicts *[]Conflict) mergeValue {
+	switch {
+	case sameMergeValue(ours, theirs):
+		return copyMergeValue(ours)
+	case sameMergeValue(base, ours):
+		return copyMergeValue(theirs)
+	case sameMergeValue(base, theirs):
+		return copyMergeValue(ours)
+	}
+
+	oursMap, oursOk := ours.value.(map[string]any)
+	theirsMap, theirsOk := theirs.value.(map[string]any)
+	baseMap, baseOk := base.value.(map[string]any)
+	if oursOk && theirsOk && (baseOk || !base.present) {
+		result := map[string]any{}
+		keys := map[string]any{}
+		for _, obj := range []map[string]any{baseMap, oursMap, theirsMap} {
+			for key := range obj {
+				keys[key] = true
+			}
+		}
+		for _, key := range sortedKeys(keys) {
+			merged := merge3(appendPath(path, key),
+				lookupMergeValue(baseMap, key),
+				lookupMergeValue(oursMap, key),
+				lookupMergeValue(theirsMap, key),
+				conflicts,
+			)
+			if merged.present {
+				result[key] = merged.value
+			}
+		}
+		return mergeValue{result, true}
+	}
+
+	*conflicts = append(*conflicts, Conflict{
+		Path:     formatPointer(path...),
+		Keys:     path,
+		Base:     base.value,
+		Ours:     ours.value,
+		Theirs:   theirs.value,
+		InBase:   base.present,
+		InOurs:   ours.present,
+		InTheirs: theirs.present,
+	})
+	return copyMergeValue(ours)
+}
+
+// sameMergeValue returns true if a and b are both absent, or both present and equal
+func sameMergeValue(a mergeValue, b mergeValue) bool {
+	if a.present != b.present {
+		return false
+	}
+	return !a.present || valuesEqual(a.value, b.value)
+}
+
+// copyMergeValue returns a deep copy of v
+func copyMergeValue(v mergeValue) mergeValue {
+	return mergeValue{deepCopy(v.value), v.present}
+}
+
+// lookupMergeValue returns the value at key within obj, which may be nil
+func lookupMergeValue(obj map[string]any, key string) mergeValue {
+	v, ok := obj[key]
+	return mergeValue{v, ok}
+}
+
+// describeMergeValue returns a short description of a value taking part in a merge
+func describeMergeValue(value any, present bool) string {
+	if !present {
+		return "absent"
+	}
+	return formatChangeValue(value)
+}
@@ -0,0 +1,73 @@
+package keyval
+
+import (
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	base, err := NewFromJson([]byte(`{"name": "app", "port": 80, "db": {"host": "h1", "pool": 4}, "tags": ["a"], "old": 1}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ours, err := NewFromJson([]byte(`{"name": "app", "port": 81, "db": {"host": "h1", "pool": 8}, "tags": ["a"], "old": 1, "ours": true}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	theirs, err := NewFromYaml([]byte("name: svc\nport: 80\ndb:\n  host: h2\n  pool: 8\ntags: [a, b]\ntheirs: true\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	merged, conflicts := Merge3(base, ours, theirs)
+	if len(conflicts) != 0 {
+		t.Errorf("Expected no conflicts, got %v", conflicts)
+		return
+	}
+	expectJson(t, merged, `{"db":{"host":"h2","pool":8},"name":"svc","ours":true,"port":81,"tags":["a","b"],"theirs":true}`)
+	expectJson(t, base, `{"db":{"host":"h1","pool":4},"name":"app","old":1,"port":80,"tags":["a"]}`)
+}
+
+func TestMerge3Conflicts(t *testing.T) {
+	base, err := NewFromJson([]byte(`{"port": 80, "db": {"host": "h1"}, "tags": ["a"], "gone": 1}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ours, err := NewFromJson([]byte(`{"port": 81, "db": {"host": "h1"}, "tags": ["a", "b"], "new": 1}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	theirs, err := NewFromJson([]byte(`{"port": 82, "db": "external", "tags": ["c"], "gone": 2, "new": 2}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	merged, conflicts := Merge3(base, ours, theirs)
+	expected := []string{"/gone", "/new", "/port", "/tags"}
+	if len(conflicts) != len(expected) {
+		t.Errorf("Expected %d conflicts, got %v", len(expected), conflicts)
+		return
+	}
+	for idx, conflict := range conflicts {
+		if conflict.Path != expected[idx] {
+			t.Errorf("Expected conflict at %s, got %s", expected[idx], conflict.Path)
+			return
+		}
+	}
+	if conflicts[0].InOurs || !conflicts[0].InTheirs || !conflicts[0].InBase {
+		t.Errorf("Unexpected presence flags for %v", conflicts[0])
+		return
+	}
+	if conflicts[2].Base != 80.0 || conflicts[2].Ours != 81.0 || conflicts[2].Theirs != 82.0 {
+		t.Errorf("Unexpected values for %v", conflicts[2])
+		return
+	}
+
+	// db was only changed by theirs, every conflict resolves to ours
+	expectJson(t, merged, `{"db":"external","new":1,"port":81,"tags":["a","b"]}`)
+}
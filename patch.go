@@ -0,0 +1,326 @@
+package keyval
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// MergePatch applies an RFC 7386 JSON Merge Patch to the object.  Mappings within the patch are merged recursively,
+// null values remove the corresponding key, and any other value replaces the existing one.
+func (kv *KeyVal) MergePatch(patch *KeyVal) error {
+	if kv.frozen {
+		return ErrFrozen
+	}
+	// Only the mappings at the positions of the patch's mappings are merged in place
+	return kv.notifyDocument(patch.root, func() error {
+		owned := kv.ownership()
+		kv.root = kv.own(owned, kv.root)
+		kv.mergePatchInto(owned, kv.root, patch.root)
+		return nil
+	})
+}
+
+// mergePatchInto applies patch to target in place, where target is owned by the KeyVal as recorded by owned
+func (kv *KeyVal) mergePatchInto(owned *ownership, target map[string]any, patch map[string]any) {
+	for key, patchVal := range patch {
+		if patchVal == nil {
+			delete(target, key)
+			owned.drop(key)
+			continue
+		}
+		patchMap, ok := patchVal.(map[string]any)
+		if !ok {
+			target[key] = deepCopy(patchVal)
+			owned.claim(key)
+			continue
+		}
+		childOwned := owned.child(key)
+		child, ok := target[key].(map[string]any)
+		if ok {
+			child = kv.own(childOwned, child)
+		} else {
+			child = kv.newMapping(childOwned)
+		}
+		target[key] = child
+		kv.mergePatchInto(childOwned, child, patchMap)
+	}
+}
+
+// PatchOperation is a single operation of an RFC 6902 JSON Patch
+type PatchOperation struct {
+	// Op is one of "add", "remove", "replace", "move", "copy" or "test"
+	Op string `json:"op"`
+	// Path is a JSON pointer to the target location
+	Path string `json:"path"`
+	// From is a JSON pointer to the source location of a move or copy
+	From string `json:"from,omitempty"`
+	// Value is the value added, substituted or tested
+	Value any `json:"value"`
+}
+
+// ParsePatch parses an RFC 6902 JSON Patch document
+func ParsePatch(data []byte) ([]PatchOperation, error) {
+	raw := []map[string]json.RawMessage{}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]PatchOperation, 0, len(raw))
+	for idx, members := range raw {
+		var op PatchOperation
+		for _, field := range []struct {
+			name     string
+			target   any
+			required bool
+		}{
+			{"op", &op.Op, true},
+			{"path", &op.Path, true},
+			{"from", &op.From, false},
+			{"value", &op.Value, false},
+		} {
+			member, ok := members[field.name]
+			if !ok {
+				if field.required {
+					return nil, fmt.Errorf("Patch operation %d is missing \"%s\"", idx, field.name)
+				}
+				continue
+			}
+			err := json.Unmarshal(member, field.target)
+			if err != nil {
+				return nil, fmt.Errorf("Patch operation %d has an invalid \"%s\": %v", idx, field.name, err)
+			}
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if _, ok := members["value"]; !ok {
+				return nil, fmt.Errorf("Patch operation %d (%s) is missing \"value\"", idx, op.Op)
+			}
+		case "move", "copy":
+			if _, ok := members["from"]; !ok {
+				return nil, fmt.Errorf("Patch operation %d (%s) is missing \"from\"", idx, op.Op)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("Patch operation %d has an unknown op \"%s\"", idx, op.Op)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// ApplyPatch applies an RFC 6902 JSON Patch to the object.  Unlike the key based methods, the patch may address the
+// elements of arrays.  The patch is atomic: if any operation fails, including a "test", the object is left unmodified
+// and an error is returned.
+func (kv *KeyVal) ApplyPatch(ops []PatchOperation) error {
+	if kv.frozen {
+		return ErrFrozen
+	}
+	result, err := applyPatch(deepCopy(kv.root), ops)
+	if err != nil {
+		return err
+	}
+	root, ok := result.(map[string]any)
+	if !ok {
+		return fmt.Errorf("Patch replaced the document root with a non-mapping value")
+	}
+
+	return kv.notifyDocument(map[string]any{}, func() error {
+		kv.root = root
+		kv.setOwnership(fullOwnership())
+		return nil
+	})
+}
+
+// applyPatch applies ops to doc, which it may modify in place, returning the resulting document
+func applyPatch(doc any, ops []PatchOperation) (any, error) {
+	for idx, op := range ops {
+		var err error
+		doc, err = applyPatchOperation(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("Patch operation %d (%s %s) failed: %v", idx, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// applyPatchOperation applies a single operation to doc, returning the resulting document
+func applyPatchOperation(doc any, op PatchOperation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		return patchAdd(doc, path, deepCopy(op.Value))
+	case "remove":
+		doc, _, err = patchRemove(doc, path)
+		return doc, err
+	case "replace":
+		if len(path) == 0 {
+			return deepCopy(op.Value), nil
+		}
+		doc, _, err = patchRemove(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return patchAdd(doc, path, deepCopy(op.Value))
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			v, err := pointerValue(doc, from)
+			if err != nil {
+				return nil, err
+			}
+			return patchAdd(doc, path, deepCopy(v))
+		}
+		if len(from) < len(path) && formatPointer(path[:len(from)]...) == formatPointer(from...) {
+			return nil, fmt.Errorf("Cannot move a value into one of its own children")
+		}
+		doc, v, err := patchRemove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return patchAdd(doc, path, v)
+	case "test":
+		v, err := pointerValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !valuesEqual(v, op.Value) {
+			return nil, fmt.Errorf("Value doesn't match")
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("Unknown op \"%s\"", op.Op)
+	}
+}
+
+// patchAdd adds value at path, inserting it into an array or setting the key of a mapping
+func patchAdd(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return patchParent(doc, path, func(parent any, token string) (any, error) {
+		switch t := parent.(type) {
+		case map[string]any:
+			t[token] = value
+			return t, nil
+		case []any:
+			idx := len(t)
+			if token != "-" {
+				var err error
+				idx, err = arrayIndex(token, len(t)+1)
+				if err != nil {
+					return nil, err
+				}
+			}
+			t = append(t, nil)
+			copy(t[idx+1:], t[idx:])
+			t[idx] = value
+			return t, nil
+		default:
+			return nil, fmt.Errorf("Parent is not a container")
+		}
+	})
+}
+
+// patchRemove removes the value at path, returning the resulting document and the removed value
+func patchRemove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("Cannot remove the document root")
+	}
+	var removed any
+	doc, err := patchParent(doc, path, func(parent any, token string) (any, error) {
+		switch t := parent.(type) {
+		case map[string]any:
+			v, ok := t[token]
+			if !ok {
+				return nil, fmt.Errorf("Key \"%s\" does not exist", token)
+			}
+			removed = v
+			delete(t, token)
+			return t, nil
+		case []any:
+			idx, err := arrayIndex(token, len(t))
+			if err != nil {
+				return nil, err
+			}
+			removed = t[idx]
+			return append(t[:idx], t[idx+1:]...), nil
+		default:
+			return nil, fmt.Errorf("Parent is not a container")
+		}
+	})
+	return doc, removed, err
+}
+
+// patchParent replaces the container holding the final token of path with the result of fn, returning the resulting
+// document.  Containers are modified in place, however arrays may be reallocated.
+func patchParent(doc any, path []string, fn func(parent any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	switch t := doc.(type) {
+	case map[string]any:
+		child, ok := t[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("Key \"%s\" does not exist", path[0])
+		}
+		child, err := patchParent(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		t[path[0]] = child
+		return t, nil
+	case []any:
+		idx, err := arrayIndex(path[0], len(t))
+		if err != nil {
+			return nil, err
+		}
+		child, err := patchParent(t[idx], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		t[idx] = child
+		return t, nil
+	default:
+		return nil, fmt.Errorf("Encountered a non-container data type while resolving pointer")
+	}
+}
+
+// arrayIndex parses an array index token, which must be less than limit
+func arrayIndex(token string, limit int) (int, error) {
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || idx >= limit || (len(token) > 1 && token[0] == '0') || token[0] == '+' {
+		return 0, fmt.Errorf("Invalid array index \"%s\"", token)
+	}
+	return idx, nil
+}
+
+// mergePatchValue applies an RFC 7386 JSON Merge Patch to target, which it may modify in place, returning the result
+func mergePatchValue(target any, patch any) any {
+	patchMap, ok := patch.(map[string]any)
+	if !ok {
+		return deepCopy(patch)
+	}
+	targetMap, ok := target.(map[string]any)
+	if !ok {
+		targetMap = map[string]any{}
+	}
+	for key, patchVal := range patchMap {
+		if patchVal == nil {
+			delete(targetMap, key)
+		} else {
+			targetMap[key] = mergePatchValue(targetMap[key], patchVal)
+		}
+	}
+	return targetMap
+}
@@ -0,0 +1,117 @@
+package keyval
+
+import (
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// Example from RFC 7386 section 3
+	kv, err := NewFromJson([]byte(`{"title": "Goodbye!", "author": {"givenName": "John", "familyName": "Doe"}, "tags": ["example", "sample"], "content": "This will be unchanged"}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	patch, err := NewFromJson([]byte(`{"title": "Hello!", "phoneNumber": "+01-123-456-7890", "author": {"familyName": null}, "tags": ["example"]}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = kv.MergePatch(patch)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, kv, `{"author":{"givenName":"John"},"content":"This will be unchanged","phoneNumber":"+01-123-456-7890","tags":["example"],"title":"Hello!"}`)
+}
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		doc      string
+		patch    string
+		expected string
+	}{
+		// Examples from RFC 6902 appendix A
+		{`{"foo": "bar"}`, `[{"op": "add", "path": "/baz", "value": "qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo": ["bar", "baz"]}`, `[{"op": "add", "path": "/foo/1", "value": "qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz": "qux", "foo": "bar"}`, `[{"op": "remove", "path": "/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo": ["bar", "qux", "baz"]}`, `[{"op": "remove", "path": "/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz": "qux", "foo": "bar"}`, `[{"op": "replace", "path": "/baz", "value": "boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`, `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo": ["all", "grass", "cows", "eat"]}`, `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz": "qux", "foo": ["a", 2, "c"]}`, `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo": "bar"}`, `[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`, `{"child":{"grandchild":{}},"foo":"bar"}`},
+		{`{"foo": ["bar"]}`, `[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"foo": null}`, `[{"op": "test", "path": "/foo", "value": null}]`, `{"foo":null}`},
+		{`{"foo": {"a": 1}}`, `[{"op": "copy", "from": "/foo", "path": "/bar"}, {"op": "add", "path": "/bar/b", "value": 2}]`, `{"bar":{"a":1,"b":2},"foo":{"a":1}}`},
+		{`{"a": 1}`, `[{"op": "replace", "path": "", "value": {"b": 2}}]`, `{"b":2}`},
+	}
+
+	for _, test := range tests {
+		kv, err := NewFromJson([]byte(test.doc))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ops, err := ParsePatch([]byte(test.patch))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = kv.ApplyPatch(ops)
+		if err != nil {
+			t.Errorf("%s: %v", test.patch, err)
+			return
+		}
+		expectJson(t, kv, test.expected)
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	patches := []string{
+		`[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+		`[{"op": "remove", "path": "/missing"}]`,
+		`[{"op": "replace", "path": "/missing", "value": 1}]`,
+		`[{"op": "add", "path": "/arr/5", "value": 1}]`,
+		`[{"op": "add", "path": "/arr/01", "value": 1}]`,
+		`[{"op": "move", "from": "/obj", "path": "/obj/child"}]`,
+		`[{"op": "test", "path": "/foo", "value": "baz"}]`,
+		`[{"op": "replace", "path": "", "value": []}]`,
+		// The first operation succeeds, but the patch as a whole doesn't
+		`[{"op": "add", "path": "/new", "value": 1}, {"op": "test", "path": "/foo", "value": 1}]`,
+	}
+
+	original := `{"arr":[1,2],"foo":"bar","obj":{"a":1}}`
+	for _, patch := range patches {
+		kv, err := NewFromJson([]byte(original))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ops, err := ParsePatch([]byte(patch))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if kv.ApplyPatch(ops) == nil {
+			t.Errorf("%s: Expected an error", patch)
+			return
+		}
+		expectJson(t, kv, original)
+	}
+
+	invalid := []string{
+		`{"op": "add"}`,
+		`[{"op": "add", "path": "/a"}]`,
+		`[{"op": "move", "path": "/a"}]`,
+		`[{"path": "/a"}]`,
+		`[{"op": "bogus", "path": "/a"}]`,
+	}
+	for _, patch := range invalid {
+		_, err := ParsePatch([]byte(patch))
+		if err == nil {
+			t.Errorf("%s: Expected a parse error", patch)
+			return
+		}
+	}
+}
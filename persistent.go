@@ -0,0 +1,194 @@
+package keyval
+
+import (
+	"sync/atomic"
+)
+
+// ownership records which mappings of a persistent KeyVal's tree the KeyVal may modify in place, mirroring the
+// structure of the tree.  A mapping is owned when it was created by the KeyVal since its tree was last shared, in
+// which case nothing else can reach it.
+type ownership struct {
+	// owned is true if the mapping at this position is owned
+	owned bool
+	// all is true if every mapping beneath this position, with no ownership of its own recorded, is owned
+	all bool
+	// children holds the ownership of the positions beneath this one
+	children map[string]*ownership
+}
+
+// child returns the ownership of the position at key, creating it if necessary.  A nil ownership, that of a
+// KeyVal which isn't persistent, has nil children.
+func (o *ownership) child(key string) *ownership {
+	if o == nil {
+		return nil
+	}
+	c, ok := o.children[key]
+	if !ok {
+		c = &ownership{owned: o.all, all: o.all}
+		if o.children == nil {
+			o.children = map[string]*ownership{}
+		}
+		o.children[key] = c
+	}
+	return c
+}
+
+// disown records that the value at key, such as one supplied by the caller, isn't owned
+func (o *ownership) disown(key string) {
+	o.set(key, &ownership{})
+}
+
+// claim records that the value at key, which was freshly copied, is owned along with everything beneath it
+func (o *ownership) claim(key string) {
+	o.set(key, fullOwnership())
+}
+
+// drop forgets the ownership of the value at key, which was removed
+func (o *ownership) drop(key string) {
+	if o != nil {
+		delete(o.children, key)
+	}
+}
+
+// set records c as the ownership of the position at key
+func (o *ownership) set(key string, c *ownership) {
+	if o == nil {
+		return
+	}
+	if o.children == nil {
+		o.children = map[string]*ownership{}
+	}
+	o.children[key] = c
+}
+
+// fullOwnership returns the ownership of a freshly copied tree, every mapping of which is owned
+func fullOwnership() *ownership {
+	return &ownership{owned: true, all: true}
+}
+
+// Persistent returns a copy of the KeyVal backed by persistent data structures.  A persistent KeyVal shares
+// structure with its copies: Copy and GetKeyVal take constant time, Stack takes time proportional to the size of the
+// layer, and SetValue and CreateValue copy only the mappings along the path they modify.  Because data is shared,
+// mappings and arrays obtained from the getters of a persistent KeyVal must not be modified directly.  Sharing
+// doesn't modify the KeyVal, so a persistent KeyVal may be copied or stacked by several goroutines at once, provided
+// none of them modifies it.
+func (kv *KeyVal) Persistent() *KeyVal {
+	return kv.inherit(newPersistent(deepCopy(kv.root).(map[string]any), fullOwnership()))
+}
+
+// IsPersistent returns true if the KeyVal is backed by persistent data structures
+func (kv *KeyVal) IsPersistent() bool {
+	return kv.owned != nil
+}
+
+// newPersistent returns a new persistent KeyVal holding root, of which it owns owned
+func newPersistent(root map[string]any, owned *ownership) *KeyVal {
+	return &KeyVal{
+		root:       root,
+		owned:      owned,
+		generation: &atomic.Uint64{},
+	}
+}
+
+// share returns a new persistent KeyVal sharing root, which is located within kv.  Since root (and anything kv
+// currently owns) is now reachable from two places, kv must relinquish ownership of everything.  Rather than
+// modifying kv, which may be read concurrently, sharing advances kv's generation, and kv relinquishes ownership the
+// next time it is modified.
+func (kv *KeyVal) share(root map[string]any, frozen bool) *KeyVal {
+	if !kv.frozen {
+		kv.generation.Add(1)
+	}
+	shared := kv.inherit(newPersistent(root, &ownership{}))
+	shared.frozen = frozen
+	return shared
+}
+
+// ownership returns the ownership of the root mapping, nil if the KeyVal isn't persistent.  Everything is
+// relinquished first if the tree was shared since ownership was last recorded.
+func (kv *KeyVal) ownership() *ownership {
+	if kv.owned == nil {
+		return nil
+	}
+	if kv.generation != nil {
+		if generation := kv.generation.Load(); generation != kv.ownedGeneration {
+			kv.owned = &ownership{}
+			kv.ownedGeneration = generation
+		}
+	}
+	return kv.owned
+}
+
+// setOwnership records owned as the ownership of the KeyVal's tree, if it is persistent
+func (kv *KeyVal) setOwnership(owned *ownership) {
+	if kv.owned == nil {
+		return
+	}
+	kv.owned = owned
+	if kv.generation != nil {
+		kv.ownedGeneration = kv.generation.Load()
+	}
+}
+
+// freeze marks the KeyVal as immutable
+func (kv *KeyVal) freeze() {
+	kv.frozen = true
+	kv.setOwnership(&ownership{})
+}
+
+// stackPersistent stacks layer atop a persistent base, sharing every mapping the layer doesn't touch
+func (kv *KeyVal) stackPersistent(layer *KeyVal) *KeyVal {
+	result := kv.share(kv.root, false)
+
+	var top map[string]any
+	if layer.owned != nil {
+		top = layer.share(layer.root, false).root
+	} else {
+		top = deepCopy(layer.root).(map[string]any)
+	}
+
+	owned := result.ownership()
+	result.root = result.own(owned, result.root)
+	result.stackInto(owned, result.root, top)
+	return result
+}
+
+// stackInto stacks layer atop target in place, where target is owned by the KeyVal as recorded by owned
+func (kv *KeyVal) stackInto(owned *ownership, target map[string]any, layer map[string]any) {
+	for key, newVal := range layer {
+		origVal, ok := target[key]
+		if ok && isMapping(newVal) && isMapping(origVal) {
+			child := owned.child(key)
+			mapping := kv.own(child, origVal.(map[string]any))
+			target[key] = mapping
+			kv.stackInto(child, mapping, newVal.(map[string]any))
+		} else {
+			// The layer's values may be shared with the layer
+			target[key] = newVal
+			owned.disown(key)
+		}
+	}
+}
+
+// newMapping returns an empty mapping which the KeyVal may modify in place, to be located at the position whose
+// ownership is owned
+func (kv *KeyVal) newMapping(owned *ownership) map[string]any {
+	if owned != nil {
+		*owned = *fullOwnership()
+	}
+	return map[string]any{}
+}
+
+// own returns obj, located at the position whose ownership is owned, if the KeyVal may modify it in place, otherwise
+// a shallow copy of obj which it may
+func (kv *KeyVal) own(owned *ownership, obj map[string]any) map[string]any {
+	if owned == nil || owned.owned {
+		return obj
+	}
+
+	target := make(map[string]any, len(obj))
+	for key, val := range obj {
+		target[key] = val
+	}
+	owned.owned = true
+	return target
+}
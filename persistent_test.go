@@ -0,0 +1,237 @@
+package keyval
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestPersistentCopyIsolation(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"a": {"b": {"c": 1}}, "d": {"e": 2}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	orig := kv.Persistent()
+	if !orig.IsPersistent() || kv.IsPersistent() {
+		t.Errorf("Expected only the result of Persistent to be persistent")
+		return
+	}
+
+	copy := orig.Copy()
+	err = copy.SetValue(10, "a", "b", "c")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = orig.CreateValue(20, "d", "f")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expectJson(t, orig, `{"a":{"b":{"c":1}},"d":{"e":2,"f":20}}`)
+	expectJson(t, copy, `{"a":{"b":{"c":10}},"d":{"e":2}}`)
+	expectJson(t, kv, `{"a":{"b":{"c":1}},"d":{"e":2}}`)
+}
+
+func TestPersistentGetKeyVal(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"a": {"b": {"c": 1}}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	orig := kv.Persistent()
+
+	sub, err := orig.GetKeyVal("a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = sub.SetValue(2, "b", "c")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = orig.SetValue(3, "a", "b", "c")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expectJson(t, orig, `{"a":{"b":{"c":3}}}`)
+	expectJson(t, sub, `{"b":{"c":2}}`)
+}
+
+func TestPersistentStack(t *testing.T) {
+	layerA := []byte(`{"hello": 1, "world": {"something": 2}, "wilbur": "razzle"}`)
+	layerB := []byte(`{"hello": 3, "yellow": 56, "world": {"another": 32, "yetanother": 33}}`)
+	kvA, err := NewFromJson(layerA)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	kvB, err := NewFromJson(layerB)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	base := kvA.Persistent()
+	top := kvB.Persistent()
+
+	final := base.Stack(top)
+	expectJson(t, final, `{"hello":3,"wilbur":"razzle","world":{"another":32,"something":2,"yetanother":33},"yellow":56}`)
+
+	mixed := base.Stack(kvB)
+	expectJson(t, mixed, `{"hello":3,"wilbur":"razzle","world":{"another":32,"something":2,"yetanother":33},"yellow":56}`)
+
+	err = final.SetValue(0, "world", "another")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = top.SetValue(1, "world", "another")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = kvB.SetValue(2, "world", "another")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, base, `{"hello":1,"wilbur":"razzle","world":{"something":2}}`)
+	expectJson(t, top, `{"hello":3,"world":{"another":1,"yetanother":33},"yellow":56}`)
+	expectJson(t, final, `{"hello":3,"wilbur":"razzle","world":{"another":0,"something":2,"yetanother":33},"yellow":56}`)
+	expectJson(t, mixed, `{"hello":3,"wilbur":"razzle","world":{"another":32,"something":2,"yetanother":33},"yellow":56}`)
+}
+
+func TestPersistentFillDefaults(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "localhost"}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defaults, err := NewFromJson([]byte(`{"db": {"port": 5432}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	orig := kv.Persistent()
+	copy := orig.Copy()
+	copy.FillDefaults(defaults)
+
+	expectJson(t, orig, `{"db":{"host":"localhost"}}`)
+	expectJson(t, copy, `{"db":{"host":"localhost","port":5432}}`)
+
+	err = copy.SetValue(1, "db", "port")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, copy, `{"db":{"host":"localhost","port":1}}`)
+}
+
+func TestPersistentConcurrentSharing(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "localhost", "port": 5432}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	base := kv.Persistent()
+	base.SetValue("db.internal", "db", "host")
+	layer := NewFromMap(map[string]any{"db": map[string]any{"tls": true}}).Persistent()
+
+	// Copies and stacks of a shared base may be taken concurrently, and modified independently
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			copy := base.Copy()
+			copy.SetValue(i, "db", "port")
+			stacked := base.Stack(layer)
+			stacked.SetValue(i, "db", "port")
+			sub, _ := base.GetKeyVal("db")
+			sub.SetValue(i, "port")
+		}(i)
+	}
+	wg.Wait()
+
+	// The base relinquished the mappings it had shared, and copies them before modifying them
+	err = base.SetValue(1, "db", "port")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	copy := base.Copy()
+	err = base.SetValue(2, "db", "port")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, copy, `{"db":{"host":"db.internal","port":1}}`)
+	expectJson(t, base, `{"db":{"host":"db.internal","port":2}}`)
+	expectJson(t, layer, `{"db":{"tls":true}}`)
+}
+
+// expectJson fails the test if kv doesn't marshal to expected
+func expectJson(t *testing.T, kv *KeyVal, expected string) {
+	t.Helper()
+	data, err := kv.ToJson()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s\n", expected, string(data))
+	}
+}
+
+// benchmarkDocument returns a document with width top level sections of width keys each
+func benchmarkDocument(width int) *KeyVal {
+	kv := New()
+	for i := 0; i < width; i++ {
+		for j := 0; j < width; j++ {
+			kv.CreateValue(fmt.Sprintf("value-%d", j), fmt.Sprintf("section%d", i), fmt.Sprintf("key%d", j))
+		}
+	}
+	return kv
+}
+
+func BenchmarkCopy(b *testing.B) {
+	kv := benchmarkDocument(100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kv.Copy().SetValue("changed", "section50", "key50")
+	}
+}
+
+func BenchmarkCopyPersistent(b *testing.B) {
+	kv := benchmarkDocument(100).Persistent()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kv.Copy().SetValue("changed", "section50", "key50")
+	}
+}
+
+func BenchmarkStack(b *testing.B) {
+	kv := benchmarkDocument(100)
+	layer := New()
+	layer.CreateValue("override", "section50", "key50")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kv.Stack(layer)
+	}
+}
+
+func BenchmarkStackPersistent(b *testing.B) {
+	kv := benchmarkDocument(100).Persistent()
+	layer := New()
+	layer.CreateValue("override", "section50", "key50")
+	layer = layer.Persistent()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kv.Stack(layer)
+	}
+}
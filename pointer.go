@@ -0,0 +1,62 @@
+package keyval
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+	pointerEscaper   = strings.NewReplacer("~", "~0", "/", "~1")
+)
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("JSON pointer \"%s\" must begin with \"/\"", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for idx, token := range tokens {
+		tokens[idx] = pointerUnescaper.Replace(token)
+	}
+	return tokens, nil
+}
+
+// formatPointer assembles reference tokens into an RFC 6901 JSON Pointer
+func formatPointer(tokens ...string) string {
+	var sb strings.Builder
+	for _, token := range tokens {
+		sb.WriteString("/")
+		sb.WriteString(pointerEscaper.Replace(token))
+	}
+	return sb.String()
+}
+
+// pointerValue returns the value within obj referenced by tokens
+func pointerValue(obj any, tokens []string) (any, error) {
+	for _, token := range tokens {
+		switch t := obj.(type) {
+		case map[string]any:
+			v, ok := t[token]
+			if !ok {
+				return nil, fmt.Errorf("Could not resolve pointer token \"%s\"", token)
+			}
+			obj = v
+		case []any:
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || idx >= len(t) {
+				return nil, fmt.Errorf("Invalid array index \"%s\"", token)
+			}
+			obj = t[idx]
+		default:
+			return nil, fmt.Errorf("Encountered a non-container data type while resolving pointer")
+		}
+	}
+
+	return obj, nil
+}
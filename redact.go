@@ -0,0 +1,135 @@
+package keyval
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RedactedMask replaces the values removed by Redacted
+const RedactedMask = "********"
+
+// DefaultRedactionRules are the rules applied by Redacted when none are given, and when a KeyVal is formatted with fmt
+var DefaultRedactionRules = []string{
+	"*password*",
+	"*passwd*",
+	"*secret*",
+	"*token*",
+	"*credential*",
+	"*private*key*",
+	"*api*key*",
+}
+
+// Redacted returns a copy of the KeyVal in which every value matched by one of rules is replaced by RedactedMask,
+// DefaultRedactionRules being used if no rules are given.  A rule is a dot separated pattern which is matched against
+// the trailing keys of each value's path, array elements being keyed by their index.  Within a key, "*" matches any
+// sequence of characters, and matching ignores case.  For instance, "*password*" matches any key containing
+// "password" at any depth, "db.password" matches the password of any mapping named db, and "*.token" matches any
+// token which isn't at the top level.  A matched mapping or array is replaced as a whole.  ToJsonWithOptions and
+// ToYamlWithOptions redact in the same way when MarshalOptions.Redact is set, keeping sensitive values out of logs and
+// dumps.  Encrypted secrets are copied as they are, and the copy has no
+// key provider.
+func (kv *KeyVal) Redacted(rules ...string) *KeyVal {
+	if len(rules) == 0 {
+		rules = DefaultRedactionRules
+	}
+	patterns := make([][]string, len(rules))
+	for idx, rule := range rules {
+		patterns[idx] = SplitKey(strings.ToLower(rule))
+	}
+
+	root := redactValue([]string{}, kv.root, patterns)
+	return NewFromMap(root.(map[string]any))
+}
+
+// Format implements fmt.Formatter, so that printing a KeyVal never reveals the values matched by
+// DefaultRedactionRules.  The %v and %s verbs print the redacted document as compact JSON, %+v indents it, and %q
+// quotes it.
+func (kv *KeyVal) Format(f fmt.State, verb rune) {
+	redacted := kv.Redacted()
+	var data []byte
+	var err error
+	if verb == 'v' && f.Flag('+') {
+		data, err = json.MarshalIndent(redacted.root, "", "  ")
+	} else {
+		data, err = json.Marshal(redacted.root)
+	}
+	if err != nil {
+		fmt.Fprintf(f, "%%!%c(ERROR: %v)", verb, err)
+		return
+	}
+
+	switch verb {
+	case 'v', 's':
+		f.Write(data)
+	case 'q':
+		f.Write([]byte(strconv.Quote(string(data))))
+	default:
+		fmt.Fprintf(f, "%%!%c(*keyval.KeyVal)", verb)
+	}
+}
+
+// redactValue returns a copy of v, located at path, with every value matched by one of patterns masked
+func redactValue(path []string, v any, patterns [][]string) any {
+	if len(path) > 0 && matchesRedaction(path, patterns) {
+		return RedactedMask
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(t))
+		for key, val := range t {
+			result[key] = redactValue(appendPath(path, key), val, patterns)
+		}
+		return result
+	case []any:
+		result := make([]any, len(t))
+		for idx, val := range t {
+			result[idx] = redactValue(appendPath(path, strconv.Itoa(idx)), val, patterns)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+// matchesRedaction returns true if the trailing keys of path match one of patterns
+func matchesRedaction(path []string, patterns [][]string) bool {
+	for _, pattern := range patterns {
+		if len(pattern) > len(path) {
+			continue
+		}
+		tail := path[len(path)-len(pattern):]
+		matched := true
+		for idx, token := range pattern {
+			if !matchGlob(token, strings.ToLower(tail[idx])) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob returns true if s matches pattern, in which "*" matches any sequence of characters
+func matchGlob(pattern string, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(s, part)
+		if idx < 0 {
+			return false
+		}
+		s = s[idx+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
@@ -0,0 +1,111 @@
+package keyval
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRedacted(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{
+		"db": {"host": "localhost", "Password": "hunter2"},
+		"users": [{"name": "admin", "token": "abc"}],
+		"token": "top",
+		"secrets": {"api": "xyz"}
+	}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expectJson(t, kv.Redacted(), `{"db":{"Password":"********","host":"localhost"},"secrets":"********","token":"********","users":[{"name":"admin","token":"********"}]}`)
+	expectJson(t, kv.Redacted("*.token", "db.host"), `{"db":{"Password":"hunter2","host":"********"},"secrets":{"api":"xyz"},"token":"top","users":[{"name":"admin","token":"********"}]}`)
+	expectJson(t, kv.Redacted("users.*"), `{"db":{"Password":"hunter2","host":"localhost"},"secrets":{"api":"xyz"},"token":"top","users":["********"]}`)
+
+	// The original is untouched
+	password, _ := kv.String("db", "Password")
+	if password != "hunter2" {
+		t.Errorf("Expected the original to be unmodified")
+		return
+	}
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{"%v", `{"db":{"Password":"********","host":"localhost"},"secrets":"********","token":"********","users":[{"name":"admin","token":"********"}]}`},
+		{"%s", `{"db":{"Password":"********","host":"localhost"},"secrets":"********","token":"********","users":[{"name":"admin","token":"********"}]}`},
+		{"%+v", "{\n  \"db\": {\n    \"Password\": \"********\",\n    \"host\": \"localhost\"\n  },\n  \"secrets\": \"********\",\n  \"token\": \"********\",\n  \"users\": [\n    {\n      \"name\": \"admin\",\n      \"token\": \"********\"\n    }\n  ]\n}"},
+		{"%d", "%!d(*keyval.KeyVal)"},
+	}
+	for _, test := range tests {
+		formatted := fmt.Sprintf(test.format, kv)
+		if formatted != test.expected {
+			t.Errorf("%s: Expected %s, got %s", test.format, test.expected, formatted)
+			return
+		}
+	}
+	if formatted := fmt.Sprint(NewSync(kv)); strings.Contains(formatted, "hunter2") {
+		t.Errorf("Expected a SyncKeyVal to be redacted, got %s", formatted)
+	}
+}
+
+func TestMarshalRedacted(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "localhost", "password": "hunter2"}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := kv.ToJsonWithOptions(MarshalOptions{Redact: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(data) != `{"db":{"host":"localhost","password":"********"}}` {
+		t.Errorf("Unexpected JSON %s", data)
+		return
+	}
+
+	data, err = NewSync(kv).ToYamlWithOptions(MarshalOptions{Redact: true, RedactionRules: []string{"db.host"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(data) != "db:\n    host: '********'\n    password: hunter2\n" {
+		t.Errorf("Unexpected YAML %q", data)
+		return
+	}
+
+	// Redaction is opt in
+	data, err = kv.ToYamlWithOptions(MarshalOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.Contains(string(data), "hunter2") {
+		t.Errorf("Expected the password to be marshaled, got %s", data)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"token", "token", true},
+		{"token", "tokens", false},
+		{"*password*", "dbpassword", true},
+		{"*password*", "password", true},
+		{"*password", "password_file", false},
+		{"*private*key*", "private_ssh_key", true},
+		{"a*a", "a", false},
+		{"*", "a/b", true},
+	}
+	for _, test := range tests {
+		if matchGlob(test.pattern, test.s) != test.match {
+			t.Errorf("Expected %s matching %s to be %v", test.pattern, test.s, test.match)
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package keyval
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRemoteInterval is the refresh interval used when RemoteOptions doesn't specify one
+	DefaultRemoteInterval = 30 * time.Second
+	// DefaultRemoteTimeout is the time allowed for each request when RemoteOptions doesn't specify one
+	DefaultRemoteTimeout = 10 * time.Second
+	// DefaultRemoteRetries is the number of retries used when RemoteOptions doesn't specify any
+	DefaultRemoteRetries = 3
+	// DefaultRemoteRetryDelay is the delay before the first retry when RemoteOptions doesn't specify one
+	DefaultRemoteRetryDelay = 500 * time.Millisecond
+	// DefaultRemoteMaxRetryDelay is the longest delay between retries when RemoteOptions doesn't specify one
+	DefaultRemoteMaxRetryDelay = 30 * time.Second
+	// DefaultRemoteMaxBodySize is the largest document accepted when RemoteOptions doesn't specify a limit
+	DefaultRemoteMaxBodySize = 10 << 20
+)
+
+// RemoteOptions controls the behavior of a Remote
+type RemoteOptions struct {
+	// Client performs the requests, http.DefaultClient if nil
+	Client *http.Client
+	// Header holds additional headers sent with every request, such as credentials
+	Header http.Header
+	// Timeout is the time allowed for each request, including reading the response, DefaultRemoteTimeout if zero
+	Timeout time.Duration
+	// Retries is the number of times a failed request is retried before giving up.  DefaultRemoteRetries is used if
+	// zero, a negative value disables retries.  Only network errors and 429 and 5xx responses are retried.
+	Retries int
+	// RetryDelay is the delay before the first retry, doubling with each subsequent retry, DefaultRemoteRetryDelay if
+	// zero.  A 429 or 503 response carrying a Retry-After header sets the delay before the next retry instead.
+	RetryDelay time.Duration
+	// MaxRetryDelay caps the delay before any retry, including one requested by a Retry-After header,
+	// DefaultRemoteMaxRetryDelay if zero
+	MaxRetryDelay time.Duration
+	// MaxBodySize is the largest document accepted, DefaultRemoteMaxBodySize if zero
+	MaxBodySize int64
+	// Interval is how often the document is refreshed once started, DefaultRemoteInterval if zero
+	Interval time.Duration
+	// CacheDir is a directory in which the last document fetched is kept, so that it can be loaded when the server
+	// is unavailable.  The document is not cached if empty.
+	CacheDir string
+	// OnError is called when the document can't be refreshed, in which case the previous version is kept
+	OnError func(err error)
+}
+
+// Remote fetches a JSON or YAML document from a URL, such as a central configuration server, periodically refreshing
+// it and notifying subscribers of changes.  Requests are conditional on the ETag and Last-Modified time of the
+// previous response, so an unchanged document isn't transferred again.  The format is determined by the response's
+// Content-Type, falling back to the URL's extension, and YAML if neither is recognized.
+type Remote struct {
+	url       string
+	opts      RemoteOptions
+	publisher publisher
+	// fetchLock serializes refreshes, guarding the validators of the current version
+	fetchLock    sync.Mutex
+	etag         string
+	lastModified string
+	lock         sync.Mutex
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+// remoteDocument is a successful response, as kept in the cache directory
+type remoteDocument struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	ContentType  string `json:"contentType,omitempty"`
+	Body         []byte `json:"body"`
+}
+
+// NewRemote returns a new Remote for rawUrl, having performed the initial fetch.  If the fetch fails but a cached
+// copy of the document exists, the cached copy is used and the error passed to OnError.  Call Start to begin
+// refreshing.
+func NewRemote(rawUrl string, opts RemoteOptions) (*Remote, error) {
+	_, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultRemoteTimeout
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	} else if opts.Retries == 0 {
+		opts.Retries = DefaultRemoteRetries
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = DefaultRemoteRetryDelay
+	}
+	if opts.MaxRetryDelay <= 0 {
+		opts.MaxRetryDelay = DefaultRemoteMaxRetryDelay
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultRemoteMaxBodySize
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultRemoteInterval
+	}
+
+	r := &Remote{
+		url:  rawUrl,
+		opts: opts,
+	}
+	cached, err := r.readCache()
+	if err != nil {
+		// A damaged cache is no worse than a missing one
+		r.reportError(err)
+	} else if cached != nil {
+		kv, err := cached.parse()
+		if err != nil {
+			r.reportError(fmt.Errorf("Unable to parse cached copy of %s: %v", rawUrl, err))
+		} else {
+			r.publisher.current = kv
+			r.etag = cached.ETag
+			r.lastModified = cached.LastModified
+		}
+	}
+
+	err = r.Refresh()
+	if err != nil {
+		if r.publisher.current == nil {
+			return nil, err
+		}
+		r.reportError(err)
+	}
+	return r, nil
+}
+
+// Current returns the most recently fetched version of the document, which must not be modified
+func (r *Remote) Current() *KeyVal {
+	return r.publisher.load()
+}
+
+// Subscribe registers fn to be called with every change to the document, returning a function which cancels the
+// subscription.  Subscribers are called sequentially, in the order the changes were made, from the goroutine
+// performing a refresh.
+func (r *Remote) Subscribe(fn func(event WatchEvent)) func() {
+	return r.publisher.subscribe(fn)
+}
+
+// Start begins refreshing the document in a background goroutine
+func (r *Remote) Start() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go r.run(r.stop, r.done)
+}
+
+// Stop stops refreshing, abandoning any request in progress
+func (r *Remote) Stop() {
+	r.lock.Lock()
+	stop, done := r.stop, r.done
+	r.stop, r.done = nil, nil
+	r.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Refresh immediately fetches the document, notifying subscribers if it changed
+func (r *Remote) Refresh() error {
+	return r.refresh(context.Background())
+}
+
+// run refreshes the document until stop is closed
+func (r *Remote) run(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := r.refresh(ctx)
+			if err != nil && ctx.Err() == nil {
+				r.reportError(err)
+			}
+		}
+	}
+}
+
+// refresh fetches the document, caching and publishing it unless the server reports it is unchanged
+func (r *Remote) refresh(ctx context.Context) error {
+	r.fetchLock.Lock()
+	defer r.fetchLock.Unlock()
+
+	doc, err := r.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return nil
+	}
+	kv, err := doc.parse()
+	if err != nil {
+		return fmt.Errorf("Unable to parse %s: %v", r.url, err)
+	}
+
+	r.etag = doc.ETag
+	r.lastModified = doc.LastModified
+	err = r.writeCache(doc)
+	if err != nil {
+		// The fetched document is still good, only the fallback is out of date
+		r.reportError(err)
+	}
+	r.publisher.publish(kv)
+	return nil
+}
+
+// fetch requests the document, retrying failures, returning nil if it hasn't changed since the current version
+func (r *Remote) fetch(ctx context.Context) (*remoteDocument, error) {
+	delay := r.opts.RetryDelay
+	for attempt := 0; ; attempt++ {
+		doc, retry, retryAfter, err := r.fetchOnce(ctx)
+		if err == nil || !retry || attempt >= r.opts.Retries {
+			return doc, err
+		}
+
+		wait := delay
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if wait > r.opts.MaxRetryDelay {
+			wait = r.opts.MaxRetryDelay
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// fetchOnce performs a single request, reporting whether a failure is worth retrying, and how long the server asked
+// to wait before retrying, zero if it didn't
+func (r *Remote) fetchOnce(ctx context.Context) (*remoteDocument, bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	for name, values := range r.opts.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json, application/yaml;q=0.9, */*;q=0.1")
+	if r.publisher.load() != nil {
+		if r.etag != "" {
+			req.Header.Set("If-None-Match", r.etag)
+		}
+		if r.lastModified != "" {
+			req.Header.Set("If-Modified-Since", r.lastModified)
+		}
+	}
+
+	resp, err := r.opts.Client.Do(req)
+	if err != nil {
+		return nil, true, 0, fmt.Errorf("Unable to fetch %s: %v", r.url, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return nil, false, 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return nil, true, retryAfter, fmt.Errorf("Unable to fetch %s: %s", r.url, resp.Status)
+	case resp.StatusCode >= 500:
+		return nil, true, 0, fmt.Errorf("Unable to fetch %s: %s", r.url, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, 0, fmt.Errorf("Unable to fetch %s: %s", r.url, resp.Status)
+	}
+
+	// Read one byte beyond the limit to tell a document of exactly the maximum size from a larger one
+	body, err := io.ReadAll(io.LimitReader(resp.Body, r.opts.MaxBodySize+1))
+	if err != nil {
+		return nil, true, 0, fmt.Errorf("Unable to fetch %s: %v", r.url, err)
+	}
+	if int64(len(body)) > r.opts.MaxBodySize {
+		return nil, false, 0, fmt.Errorf("Unable to fetch %s: document exceeds %d bytes", r.url, r.opts.MaxBodySize)
+	}
+	return &remoteDocument{
+		URL:          r.url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+		Body:         body,
+	}, false, 0, nil
+}
+
+// parseRetryAfter returns the delay requested by a Retry-After header, given either as a number of seconds or as an
+// HTTP date, zero if the header is absent, invalid or in the past
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	date, err := http.ParseTime(header)
+	if err != nil || !date.After(now) {
+		return 0
+	}
+	return date.Sub(now)
+}
+
+// parse parses the body of the document according to its content type or URL
+func (d *remoteDocument) parse() (*KeyVal, error) {
+	mediaType, _, _ := mime.ParseMediaType(d.ContentType)
+	switch {
+	case mediaType == mediaJson || strings.HasSuffix(mediaType, "+json"):
+		return NewFromJson(d.Body)
+	case strings.Contains(mediaType, "yaml"):
+		return NewFromYaml(d.Body)
+	}
+
+	if u, err := url.Parse(d.URL); err == nil && strings.ToLower(path.Ext(u.Path)) == ".json" {
+		return NewFromJson(d.Body)
+	}
+	// YAML is a superset of JSON, so it's the safest guess
+	return NewFromYaml(d.Body)
+}
+
+// cachePath returns the path of the cached copy of the document, or an empty string if caching is disabled
+func (r *Remote) cachePath() string {
+	if r.opts.CacheDir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(r.url))
+	return filepath.Join(r.opts.CacheDir, "remote-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// readCache returns the cached copy of the document, or nil if there isn't one
+func (r *Remote) readCache() (*remoteDocument, error) {
+	name := r.cachePath()
+	if name == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var doc remoteDocument
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read cache \"%s\": %v", name, err)
+	}
+	if doc.URL != r.url {
+		// A hash collision, so the cache belongs to another document
+		return nil, nil
+	}
+	return &doc, nil
+}
+
+// writeCache atomically replaces the cached copy of the document
+func (r *Remote) writeCache(doc *remoteDocument) error {
+	name := r.cachePath()
+	if name == "" {
+		return nil
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(r.opts.CacheDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(r.opts.CacheDir, filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// reportError passes err to the configured error handler
+func (r *Remote) reportError(err error) {
+	if r.opts.OnError != nil {
+		r.opts.OnError(err)
+	}
+}
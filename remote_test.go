@@ -0,0 +1,270 @@
+package keyval
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer serves a document which may be changed, counting full and conditional responses
+type testServer struct {
+	lock        sync.Mutex
+	body        string
+	etag        string
+	contentType string
+	status      int
+	full        int
+	notModified int
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	if r.Header.Get("If-None-Match") == s.etag {
+		s.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.full++
+	w.Header().Set("ETag", s.etag)
+	w.Header().Set("Content-Type", s.contentType)
+	w.Write([]byte(s.body))
+}
+
+// set changes the served document
+func (s *testServer) set(body string, etag string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.body = body
+	s.etag = etag
+}
+
+func TestRemote(t *testing.T) {
+	ts := &testServer{body: "db:\n  port: 5432\n", etag: `"1"`, contentType: "application/yaml"}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+
+	errs := make(chan error, 10)
+	r, err := NewRemote(server.URL+"/config", RemoteOptions{
+		Interval: 5 * time.Millisecond,
+		OnError: func(err error) {
+			errs <- err
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, r.Current(), `{"db":{"port":5432}}`)
+
+	// An unchanged document isn't transferred again
+	err = r.Refresh()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ts.lock.Lock()
+	full, notModified := ts.full, ts.notModified
+	ts.lock.Unlock()
+	if full != 1 || notModified != 1 {
+		t.Errorf("Expected 1 full and 1 conditional response, got %d and %d", full, notModified)
+		return
+	}
+
+	events := make(chan WatchEvent, 10)
+	r.Subscribe(func(event WatchEvent) {
+		events <- event
+	})
+	r.Start()
+	defer r.Stop()
+
+	ts.set(`{"db": {"port": 5433}}`, `"2"`)
+	event, ok := waitForEvent(t, events)
+	if !ok {
+		return
+	}
+	if len(event.Changes) != 1 || event.Changes[0].Path != "/db/port" {
+		t.Errorf("Unexpected changes %v", event.Changes)
+		return
+	}
+	expectJson(t, r.Current(), `{"db":{"port":5433}}`)
+
+	// A failed refresh is reported and the previous version kept
+	ts.lock.Lock()
+	ts.status = http.StatusNotFound
+	ts.lock.Unlock()
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for error")
+		return
+	}
+	expectJson(t, r.Current(), `{"db":{"port":5433}}`)
+}
+
+func TestRemoteRetries(t *testing.T) {
+	var lock sync.Mutex
+	failures := 2
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests++
+		if requests <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"name": "app"}`))
+	}))
+	defer server.Close()
+
+	r, err := NewRemote(server.URL+"/config.json", RemoteOptions{RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, r.Current(), `{"name":"app"}`)
+
+	lock.Lock()
+	requests = 0
+	lock.Unlock()
+	_, err = NewRemote(server.URL, RemoteOptions{Retries: -1})
+	if err == nil {
+		t.Errorf("Expected an error without retries")
+		return
+	}
+
+	// Requests exceeding the timeout fail
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	_, err = NewRemote(slow.URL, RemoteOptions{Timeout: 10 * time.Millisecond, Retries: -1})
+	if err == nil {
+		t.Errorf("Expected a timeout")
+		return
+	}
+
+	// A Retry-After header sets the delay before the next retry, capped at MaxRetryDelay
+	limited := 0
+	throttled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limited++
+		if limited == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"name": "app"}`))
+	}))
+	defer throttled.Close()
+	start := time.Now()
+	r, err = NewRemote(throttled.URL, RemoteOptions{RetryDelay: time.Millisecond, MaxRetryDelay: 50 * time.Millisecond})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("Expected the retry to wait for the capped Retry-After delay, waited %v", elapsed)
+		return
+	}
+	expectJson(t, r.Current(), `{"name":"app"}`)
+}
+
+func TestRemoteMaxBodySize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name": "app"}`))
+	}))
+	defer server.Close()
+
+	_, err := NewRemote(server.URL, RemoteOptions{MaxBodySize: 10})
+	if err == nil || !strings.Contains(err.Error(), "exceeds 10 bytes") {
+		t.Errorf("Expected an oversized document to be rejected, got %v", err)
+		return
+	}
+	r, err := NewRemote(server.URL, RemoteOptions{MaxBodySize: 15})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, r.Current(), `{"name":"app"}`)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header   string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-1", 0},
+		{"soon", 0},
+		{"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0},
+	}
+	for _, test := range tests {
+		if delay := parseRetryAfter(test.header, now); delay != test.expected {
+			t.Errorf("%q: Expected %v, got %v", test.header, test.expected, delay)
+		}
+	}
+}
+
+func TestRemoteCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	ts := &testServer{body: `{"name": "app"}`, etag: `"1"`, contentType: "application/json"}
+	server := httptest.NewServer(ts)
+	url := server.URL + "/config"
+
+	_, err := NewRemote(url, RemoteOptions{CacheDir: cacheDir})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A cached copy which is still current isn't transferred again
+	r, err := NewRemote(url, RemoteOptions{CacheDir: cacheDir})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, r.Current(), `{"name":"app"}`)
+	if ts.full != 1 || ts.notModified != 1 {
+		t.Errorf("Expected 1 full and 1 conditional response, got %d and %d", ts.full, ts.notModified)
+		return
+	}
+
+	// The cached copy is used when the server is unavailable
+	server.Close()
+	errs := []error{}
+	r, err = NewRemote(url, RemoteOptions{
+		CacheDir: cacheDir,
+		Retries:  -1,
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, r.Current(), `{"name":"app"}`)
+	if len(errs) != 1 {
+		t.Errorf("Expected the failed fetch to be reported, got %v", errs)
+		return
+	}
+
+	_, err = NewRemote(url, RemoteOptions{CacheDir: t.TempDir(), Retries: -1})
+	if err == nil {
+		t.Errorf("Expected an error without a cached copy")
+	}
+}
@@ -0,0 +1,431 @@
+package keyval
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiMagenta = "\x1b[35m"
+)
+
+// DefaultUnifiedContext is the number of unchanged lines UnifiedDiff shows around each change when
+// UnifiedOptions.Context is zero, as with diff -u
+const DefaultUnifiedContext = 3
+
+// changeStyles holds the symbol and color used to render each kind of change
+var changeStyles = map[ChangeKind]struct {
+	symbol string
+	color  string
+}{
+	ChangeAdded:       {"+", ansiGreen},
+	ChangeRemoved:     {"-", ansiRed},
+	ChangeModified:    {"~", ansiYellow},
+	ChangeTypeChanged: {"!", ansiMagenta},
+}
+
+// UnifiedOptions controls the output of UnifiedDiff
+type UnifiedOptions struct {
+	// FromName and ToName label the original and updated documents, "a" and "b" if empty
+	FromName string
+	ToName   string
+	// Context is the number of unchanged lines shown around each change, DefaultUnifiedContext if zero, and none if
+	// negative
+	Context int
+	// Json compares indented JSON rather than YAML
+	Json bool
+}
+
+// renderNode is a single key within the tree rendering of a set of changes
+type renderNode struct {
+	change   *Change
+	children map[string]*renderNode
+}
+
+// lineOp is a single line of an edit script, kind being one of ' ', '-' or '+'
+type lineOp struct {
+	kind  byte
+	text  string
+	lineA int
+	lineB int
+}
+
+// RenderTree renders changes as an indented tree of keys, marking each change with a symbol (+ added, - removed,
+// ~ modified, ! type changed).  When color is true, changes are colored using ANSI escape sequences.
+func RenderTree(changes []Change, color bool) string {
+	root := &renderNode{
+		children: map[string]*renderNode{},
+	}
+	for idx := range changes {
+		node := root
+		for _, key := range changes[idx].Keys {
+			child, ok := node.children[key]
+			if !ok {
+				child = &renderNode{
+					children: map[string]*renderNode{},
+				}
+				node.children[key] = child
+			}
+			node = child
+		}
+		node.change = &changes[idx]
+	}
+
+	var sb strings.Builder
+	if root.change != nil {
+		writeTreeChange(&sb, "(root)", root.change, 0, color)
+	}
+	writeTreeChildren(&sb, root, 0, color)
+	return sb.String()
+}
+
+// RenderMarkdown renders changes as a Markdown table
+func RenderMarkdown(changes []Change) string {
+	var sb strings.Builder
+	sb.WriteString("| Path | Change | Old | New |\n")
+	sb.WriteString("| --- | --- | --- | --- |\n")
+	for _, change := range changes {
+		path := change.Path
+		if path == "" {
+			path = "/"
+		}
+		oldVal, newVal := "", ""
+		if change.Kind != ChangeAdded {
+			oldVal = markdownCode(formatChangeValue(change.Old))
+		}
+		if change.Kind != ChangeRemoved {
+			newVal = markdownCode(formatChangeValue(change.New))
+		}
+		fmt.Fprintf(&sb, "| %s | %s | %s | %s |\n", markdownCode(path), change.Kind, oldVal, newVal)
+	}
+	return sb.String()
+}
+
+// UnifiedDiff returns a unified diff between the canonical text of documents a and b, being their YAML (or indented
+// JSON) representation with keys sorted.  An empty string is returned if the documents are textually identical.
+func UnifiedDiff(a *KeyVal, b *KeyVal, opts UnifiedOptions) (string, error) {
+	if opts.FromName == "" {
+		opts.FromName = "a"
+	}
+	if opts.ToName == "" {
+		opts.ToName = "b"
+	}
+	if opts.Context == 0 {
+		opts.Context = DefaultUnifiedContext
+	} else if opts.Context < 0 {
+		opts.Context = 0
+	}
+
+	textA, err := canonicalText(a, opts.Json)
+	if err != nil {
+		return "", err
+	}
+	textB, err := canonicalText(b, opts.Json)
+	if err != nil {
+		return "", err
+	}
+
+	ops := diffLines(splitLines(textA), splitLines(textB))
+	var sb strings.Builder
+	for _, hunk := range groupHunks(ops, opts.Context) {
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", opts.FromName, opts.ToName)
+		}
+		writeHunk(&sb, hunk)
+	}
+	return sb.String(), nil
+}
+
+// writeTreeChildren writes each child of node, sorted by key
+func writeTreeChildren(sb *strings.Builder, node *renderNode, depth int, color bool) {
+	keys := make([]string, 0, len(node.children))
+	for key := range node.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		child := node.children[key]
+		if child.change != nil {
+			writeTreeChange(sb, key, child.change, depth, color)
+		} else {
+			fmt.Fprintf(sb, "%s  %s\n", strings.Repeat("  ", depth), key)
+		}
+		writeTreeChildren(sb, child, depth+1, color)
+	}
+}
+
+// writeTreeChange writes a single change within the tree
+func writeTreeChange(sb *strings.Builder, key string, change *Change, depth int, color bool) {
+	style := changeStyles[change.Kind]
+	var line string
+	switch change.Kind {
+	case ChangeAdded:
+		line = fmt.Sprintf("%s %s: %s", style.symbol, key, formatChangeValue(change.New))
+	case ChangeRemoved:
+		line = fmt.Sprintf("%s %s: %s", style.symbol, key, formatChangeValue(change.Old))
+	default:
+		line = fmt.Sprintf("%s %s: %s -> %s", style.symbol, key, formatChangeValue(change.Old), formatChangeValue(change.New))
+	}
+	if color {
+		line = style.color + line + ansiReset
+	}
+	fmt.Fprintf(sb, "%s%s\n", strings.Repeat("  ", depth), line)
+}
+
+// formatChangeValue returns the compact JSON representation of value
+func formatChangeValue(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// markdownCode formats text as inline code within a Markdown table cell
+func markdownCode(text string) string {
+	return "`" + strings.ReplaceAll(text, "|", "\\|") + "`"
+}
+
+// canonicalText returns the YAML or indented JSON text of kv
+func canonicalText(kv *KeyVal, asJson bool) (string, error) {
+	if !asJson {
+		data, err := kv.ToYaml()
+		return string(data), err
+	}
+
+	data, err := kv.ToJson()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = json.Indent(&buf, data, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	buf.WriteString("\n")
+	return buf.String(), nil
+}
+
+// splitLines splits text into lines, ignoring any trailing newline
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
+}
+
+// diffLines returns the shortest edit script transforming a into b, using the linear space refinement of Myers'
+// algorithm, which finds the middle of an optimal path and recurses on either side of it rather than retaining the
+// furthest reaching path of every step.  Deletions precede insertions within each run of changes.
+func diffLines(a []string, b []string) []lineOp {
+	d := &lineDiff{
+		a:        a,
+		b:        b,
+		forward:  make([]int, len(a)+len(b)+3),
+		backward: make([]int, len(a)+len(b)+3),
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	// Order each run of changes, then number the lines
+	ops := d.ops
+	for start := 0; start < len(ops); {
+		end := start
+		for end < len(ops) && ops[end].kind != ' ' {
+			end++
+		}
+		sort.SliceStable(ops[start:end], func(i, j int) bool {
+			return ops[start+i].kind == '-' && ops[start+j].kind == '+'
+		})
+		if end == start {
+			end++
+		}
+		start = end
+	}
+	lineA, lineB := 0, 0
+	for idx := range ops {
+		if ops[idx].kind != '+' {
+			lineA++
+		}
+		if ops[idx].kind != '-' {
+			lineB++
+		}
+		ops[idx].lineA, ops[idx].lineB = lineA, lineB
+	}
+	return ops
+}
+
+// lineDiff accumulates the edit script transforming a into b
+type lineDiff struct {
+	a   []string
+	b   []string
+	ops []lineOp
+	// forward and backward hold the furthest reaching paths of the current step from either end, indexed by diagonal
+	forward  []int
+	backward []int
+}
+
+// compare appends the edit script transforming a[aLo:aHi] into b[bLo:bHi]
+func (d *lineDiff) compare(aLo int, aHi int, bLo int, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.ops = append(d.ops, lineOp{kind: ' ', text: d.a[aLo]})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-1-suffix] == d.b[bHi-1-suffix] {
+		suffix++
+	}
+	aHi -= suffix
+	bHi -= suffix
+
+	switch {
+	case aLo == aHi:
+		for _, line := range d.b[bLo:bHi] {
+			d.ops = append(d.ops, lineOp{kind: '+', text: line})
+		}
+	case bLo == bHi:
+		for _, line := range d.a[aLo:aHi] {
+			d.ops = append(d.ops, lineOp{kind: '-', text: line})
+		}
+	default:
+		x, y := d.split(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		d.compare(x, aHi, y, bHi)
+	}
+
+	for _, line := range d.a[aHi : aHi+suffix] {
+		d.ops = append(d.ops, lineOp{kind: ' ', text: line})
+	}
+}
+
+// split returns a point, strictly between the ends, on an optimal path transforming a[aLo:aHi] into b[bLo:bHi],
+// which neither start nor end with a common line.  Paths are extended from both ends one edit at a time until they
+// overlap.
+func (d *lineDiff) split(aLo int, aHi int, bLo int, bHi int) (int, int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	// Diagonal k, on which x-y = k, is stored at offset+k
+	offset := (n+m+1)/2 + 1
+	forward, backward := d.forward, d.backward
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+
+	for step := 0; step <= (n+m+1)/2; step++ {
+		for k := -step; k <= step; k += 2 {
+			var x int
+			if k == -step || (k != step && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			// The backward paths of the previous step lie on the diagonals of the opposite parity
+			if reverse := delta - k; odd && reverse >= -(step-1) && reverse <= step-1 {
+				if x+backward[offset+reverse] >= n {
+					return aLo + x, bLo + y
+				}
+			}
+		}
+
+		// Backward paths run from the ends of a and b, x counting the lines consumed from the end of a
+		for k := -step; k <= step; k += 2 {
+			var x int
+			if k == -step || (k != step && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			if reverse := delta - k; !odd && reverse >= -step && reverse <= step {
+				if forwardX := forward[offset+reverse]; forwardX+x >= n {
+					return aLo + forwardX, bLo + forwardX - reverse
+				}
+			}
+		}
+	}
+	// Unreachable, the paths overlap once they span the difference between a and b
+	return aLo, bLo
+}
+
+// groupHunks splits an edit script into hunks of changes surrounded by up to context unchanged lines
+func groupHunks(ops []lineOp, context int) [][]lineOp {
+	hunks := [][]lineOp{}
+	start, end := -1, -1
+	for idx, op := range ops {
+		if op.kind == ' ' {
+			continue
+		}
+		lo := idx - context
+		if lo < 0 {
+			lo = 0
+		}
+		hi := idx + context + 1
+		if hi > len(ops) {
+			hi = len(ops)
+		}
+		if start >= 0 && lo <= end {
+			end = hi
+			continue
+		}
+		if start >= 0 {
+			hunks = append(hunks, ops[start:end])
+		}
+		start, end = lo, hi
+	}
+	if start >= 0 {
+		hunks = append(hunks, ops[start:end])
+	}
+	return hunks
+}
+
+// writeHunk writes a single hunk in unified diff format
+func writeHunk(sb *strings.Builder, hunk []lineOp) {
+	startA, startB := 0, 0
+	countA, countB := 0, 0
+	for _, op := range hunk {
+		if op.kind != '+' {
+			if countA == 0 {
+				startA = op.lineA
+			}
+			countA++
+		}
+		if op.kind != '-' {
+			if countB == 0 {
+				startB = op.lineB
+			}
+			countB++
+		}
+	}
+	// An empty range is identified by the line preceding it
+	if countA == 0 {
+		startA = hunk[0].lineA
+	}
+	if countB == 0 {
+		startB = hunk[0].lineB
+	}
+
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", startA, countA, startB, countB)
+	for _, op := range hunk {
+		fmt.Fprintf(sb, "%c%s\n", op.kind, op.text)
+	}
+}
@@ -0,0 +1,205 @@
+package keyval
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// renderDocuments returns a pair of documents with a handful of differences
+func renderDocuments(t *testing.T) (*KeyVal, *KeyVal) {
+	t.Helper()
+	a, err := NewFromJson([]byte(`{"name": "app", "port": 80, "db": {"host": "h1", "pool": 4}, "gone": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewFromJson([]byte(`{"name": "app", "port": "80", "db": {"host": "h2", "pool": 4, "tls": true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, b
+}
+
+func TestRenderTree(t *testing.T) {
+	a, b := renderDocuments(t)
+	output := RenderTree(Diff(a, b), false)
+	expected := `  db
+  ~ host: "h1" -> "h2"
+  + tls: true
+- gone: true
+! port: 80 -> "80"
+`
+	if output != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s\n", expected, output)
+		return
+	}
+
+	colored := RenderTree(Diff(a, b), true)
+	if !strings.Contains(colored, ansiGreen+"+ tls: true"+ansiReset) {
+		t.Errorf("Expected colored output, got:\n%s", colored)
+		return
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	a, b := renderDocuments(t)
+	output := RenderMarkdown(Diff(a, b))
+	expected := "| Path | Change | Old | New |\n" +
+		"| --- | --- | --- | --- |\n" +
+		"| `/db/host` | modified | `\"h1\"` | `\"h2\"` |\n" +
+		"| `/db/tls` | added |  | `true` |\n" +
+		"| `/gone` | removed | `true` |  |\n" +
+		"| `/port` | type-changed | `80` | `\"80\"` |\n"
+	if output != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s\n", expected, output)
+		return
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a, b := renderDocuments(t)
+	output, err := UnifiedDiff(a, b, UnifiedOptions{FromName: "old.yaml", ToName: "new.yaml", Context: 1})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := `--- old.yaml
++++ new.yaml
+@@ -1,6 +1,6 @@
+ db:
+-    host: h1
++    host: h2
+     pool: 4
+-gone: true
++    tls: true
+ name: app
+-port: 80
++port: "80"
+`
+	if output != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s\n", expected, output)
+		return
+	}
+
+	output, err = UnifiedDiff(a, a.Copy(), UnifiedOptions{Json: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if output != "" {
+		t.Errorf("Expected no output for identical documents, got:\n%s", output)
+		return
+	}
+}
+
+func TestUnifiedDiffHunks(t *testing.T) {
+	a := New()
+	b := New()
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		a.CreateValue(1, key)
+		b.CreateValue(1, key)
+	}
+	b.SetValue(2, "b")
+	b.SetValue(2, "i")
+
+	output, err := UnifiedDiff(a, b, UnifiedOptions{Context: 1, Json: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := `--- a
++++ b
+@@ -2,3 +2,3 @@
+   "a": 1,
+-  "b": 1,
++  "b": 2,
+   "c": 1,
+@@ -9,3 +9,3 @@
+   "h": 1,
+-  "i": 1,
++  "i": 2,
+   "j": 1
+`
+	if output != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s\n", expected, output)
+		return
+	}
+
+	// The zero value shows three lines of context, like diff -u, and a negative count none
+	for _, test := range []struct {
+		context int
+		header  string
+	}{
+		{0, "@@ -1,12 +1,12 @@\n"},
+		{-1, "@@ -3,1 +3,1 @@\n"},
+	} {
+		output, err = UnifiedDiff(a, b, UnifiedOptions{Context: test.context, Json: true})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !strings.Contains(output, test.header) {
+			t.Errorf("Expected context %d to produce %q, got:\n%s", test.context, test.header, output)
+			return
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		result := make([]string, random.Intn(30))
+		for idx := range result {
+			result[idx] = string(rune('a' + random.Intn(4)))
+		}
+		return result
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := lines(), lines()
+		ops := diffLines(a, b)
+
+		// The script transforms a into b, with as few edits as the longest common subsequence allows
+		fromA, fromB := []string{}, []string{}
+		edits := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				fromA = append(fromA, op.text)
+			}
+			if op.kind != '-' {
+				fromB = append(fromB, op.text)
+			}
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+		if strings.Join(fromA, "") != strings.Join(a, "") || strings.Join(fromB, "") != strings.Join(b, "") {
+			t.Errorf("Edit script for %v -> %v is invalid: %v", a, b, ops)
+			return
+		}
+		if expected := len(a) + len(b) - 2*longestCommonSubsequence(a, b); edits != expected {
+			t.Errorf("Expected %d edits for %v -> %v, got %d", expected, a, b, edits)
+			return
+		}
+	}
+}
+
+// longestCommonSubsequence returns the length of the longest common subsequence of a and b
+func longestCommonSubsequence(a []string, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] > lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	return lengths[0][0]
+}
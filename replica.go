@@ -0,0 +1,347 @@
+package keyval
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Timestamp is a hybrid logical clock reading, combining wall clock time with a logical counter so that timestamps
+// issued by a node always increase, and exceed every timestamp the node has received from others, even if the clocks
+// of the nodes disagree.  The node identifier breaks ties, so timestamps are totally ordered.
+type Timestamp struct {
+	// Wall is the wall clock time in nanoseconds since the Unix epoch
+	Wall int64 `json:"wall"`
+	// Logical orders timestamps sharing the same wall clock time
+	Logical uint32 `json:"logical"`
+	// Node identifies the replica which issued the timestamp
+	Node string `json:"node"`
+}
+
+// Compare returns -1 if t is before other, 1 if it is after, and 0 if they are identical
+func (t Timestamp) Compare(other Timestamp) int {
+	switch {
+	case t.Wall != other.Wall:
+		return compareOrder(t.Wall < other.Wall)
+	case t.Logical != other.Logical:
+		return compareOrder(t.Logical < other.Logical)
+	case t.Node != other.Node:
+		return compareOrder(t.Node < other.Node)
+	default:
+		return 0
+	}
+}
+
+// compareOrder returns -1 if before is true, otherwise 1
+func compareOrder(before bool) int {
+	if before {
+		return -1
+	}
+	return 1
+}
+
+// Replica is a replicated document which may be edited independently on several nodes, for instance while they're
+// offline, and later reconciled by exchanging op logs with Export and Import.  Replicas which have received the same
+// operations hold the same document, regardless of the order in which the operations arrived or how often they were
+// received.
+//
+// Each value is a last-writer-wins register: of two concurrent edits of the same value, the one with the later
+// Timestamp wins.  Mappings merge key by key, so concurrent edits of different keys are all kept, while arrays are
+// replaced as a whole.  Deleting a value removes the edits made beneath it before the deletion, but not concurrent
+// edits made after it.  Deletions are retained as tombstones, so the op log grows with the number of distinct paths
+// ever deleted.  A Replica must not be used by several goroutines at once.
+type Replica struct {
+	node  string
+	clock Timestamp
+	now   func() time.Time
+	// entries holds the value at each path which was set, keyed by JSON pointer
+	entries map[string]replicaOp
+	// tombstones holds the most recent deletion of each path, keyed by JSON pointer
+	tombstones map[string]replicaOp
+	// frontier holds the latest timestamp received from each node
+	frontier map[string]Timestamp
+}
+
+// replicaOp is a single entry of the op log, either setting the value at keys or deleting everything at or beneath
+// keys older than the operation.  A value set to a mapping only ensures that a mapping exists at keys, the keys of
+// the mapping being set by operations of their own.
+type replicaOp struct {
+	Op    string    `json:"op"`
+	Keys  []string  `json:"keys"`
+	Value any       `json:"value,omitempty"`
+	Time  Timestamp `json:"time"`
+}
+
+const (
+	replicaSet    = "set"
+	replicaDelete = "delete"
+)
+
+// NewReplica returns an empty Replica for node, which must identify it uniquely among the replicas of the document
+func NewReplica(node string) *Replica {
+	return &Replica{
+		node:       node,
+		now:        time.Now,
+		entries:    map[string]replicaOp{},
+		tombstones: map[string]replicaOp{},
+		frontier:   map[string]Timestamp{},
+	}
+}
+
+// Node returns the identifier of the replica's node
+func (r *Replica) Node() string {
+	return r.node
+}
+
+// Set sets the value at keys, creating any missing parents.  As with Delete, edits made beneath keys before the value
+// was set are discarded, even if they arrive later.  With no keys, the whole document is replaced, so value must be a
+// mapping.  The value must be representable as JSON.
+func (r *Replica) Set(value any, keys ...string) error {
+	value, err := normalizeValue(value)
+	if err != nil {
+		return err
+	}
+	if _, ok := value.(map[string]any); len(keys) == 0 && !ok {
+		return fmt.Errorf("The document root must be a mapping")
+	}
+
+	ts := r.tick()
+	// Parents which aren't mappings are replaced by mappings, without disturbing those which are
+	for idx := 1; idx < len(keys); idx++ {
+		r.apply(replicaOp{Op: replicaSet, Keys: copyKeys(keys[:idx]), Value: map[string]any{}, Time: ts})
+	}
+	r.apply(replicaOp{Op: replicaDelete, Keys: copyKeys(keys), Time: ts})
+	r.setLeaves(copyKeys(keys), value, ts)
+	return nil
+}
+
+// Delete removes the value at keys, along with everything beneath it
+func (r *Replica) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("Cannot delete the document root")
+	}
+	r.apply(replicaOp{Op: replicaDelete, Keys: copyKeys(keys), Time: r.tick()})
+	return nil
+}
+
+// Document returns the current content of the replica as a new KeyVal
+func (r *Replica) Document() *KeyVal {
+	ops := make([]replicaOp, 0, len(r.entries))
+	for _, op := range r.entries {
+		ops = append(ops, op)
+	}
+	// Applying values in timestamp order lets later edits replace the structure left by earlier ones.  The values
+	// set by a single operation share a timestamp and are applied parents first.
+	sort.Slice(ops, func(i, j int) bool {
+		if cmp := ops[i].Time.Compare(ops[j].Time); cmp != 0 {
+			return cmp < 0
+		}
+		if len(ops[i].Keys) != len(ops[j].Keys) {
+			return len(ops[i].Keys) < len(ops[j].Keys)
+		}
+		return formatPointer(ops[i].Keys...) < formatPointer(ops[j].Keys...)
+	})
+
+	root := map[string]any{}
+	for _, op := range ops {
+		if len(op.Keys) == 0 {
+			continue
+		}
+		parent := root
+		for _, key := range op.Keys[:len(op.Keys)-1] {
+			child, ok := parent[key].(map[string]any)
+			if !ok {
+				child = map[string]any{}
+				parent[key] = child
+			}
+			parent = child
+		}
+		key := op.Keys[len(op.Keys)-1]
+		if _, ok := op.Value.(map[string]any); ok {
+			if _, ok := parent[key].(map[string]any); !ok {
+				parent[key] = map[string]any{}
+			}
+			continue
+		}
+		parent[key] = deepCopy(op.Value)
+	}
+	return NewFromMap(root)
+}
+
+// Frontier returns the latest timestamp the replica has received from each node, including its own.  Passing another
+// replica's frontier to Export produces only the operations that replica hasn't seen.
+func (r *Replica) Frontier() map[string]Timestamp {
+	frontier := make(map[string]Timestamp, len(r.frontier))
+	for node, ts := range r.frontier {
+		frontier[node] = ts
+	}
+	return frontier
+}
+
+// Export encodes the op log for Import by another replica.  If since is non-nil, only operations later than the
+// timestamp it holds for their node are included.  Operations superseded by later ones are never included.
+func (r *Replica) Export(since map[string]Timestamp) ([]byte, error) {
+	ops := []replicaOp{}
+	for _, source := range []map[string]replicaOp{r.tombstones, r.entries} {
+		for _, op := range source {
+			seen, ok := since[op.Time.Node]
+			if !ok || op.Time.Compare(seen) > 0 {
+				ops = append(ops, op)
+			}
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if cmp := ops[i].Time.Compare(ops[j].Time); cmp != 0 {
+			return cmp < 0
+		}
+		if ops[i].Op != ops[j].Op {
+			return ops[i].Op == replicaDelete
+		}
+		return formatPointer(ops[i].Keys...) < formatPointer(ops[j].Keys...)
+	})
+	return json.Marshal(ops)
+}
+
+// Import merges an op log produced by Export into the replica.  Importing is idempotent, and the order in which op
+// logs are imported doesn't affect the result.  If the op log is invalid, nothing is imported.
+func (r *Replica) Import(data []byte) error {
+	ops := []replicaOp{}
+	err := json.Unmarshal(data, &ops)
+	if err != nil {
+		return err
+	}
+	for idx, op := range ops {
+		if op.Op != replicaSet && op.Op != replicaDelete {
+			return fmt.Errorf("Operation %d has an unknown op \"%s\"", idx, op.Op)
+		}
+		if op.Time.Node == "" {
+			return fmt.Errorf("Operation %d has no node", idx)
+		}
+		if op.Op == replicaSet && len(op.Keys) == 0 {
+			return fmt.Errorf("Operation %d sets the document root", idx)
+		}
+	}
+
+	for _, op := range ops {
+		if op.Keys == nil {
+			op.Keys = []string{}
+		}
+		r.observe(op.Time)
+		r.apply(op)
+	}
+	return nil
+}
+
+// tick issues a new timestamp, later than every timestamp issued or received so far
+func (r *Replica) tick() Timestamp {
+	wall := r.now().UnixNano()
+	if wall > r.clock.Wall {
+		r.clock.Wall = wall
+		r.clock.Logical = 0
+	} else {
+		r.clock.Logical++
+	}
+	ts := Timestamp{
+		Wall:    r.clock.Wall,
+		Logical: r.clock.Logical,
+		Node:    r.node,
+	}
+	r.frontier[r.node] = ts
+	return ts
+}
+
+// observe advances the clock and frontier past a received timestamp
+func (r *Replica) observe(ts Timestamp) {
+	if ts.Wall > r.clock.Wall || (ts.Wall == r.clock.Wall && ts.Logical > r.clock.Logical) {
+		r.clock.Wall = ts.Wall
+		r.clock.Logical = ts.Logical
+	}
+	if seen, ok := r.frontier[ts.Node]; !ok || ts.Compare(seen) > 0 {
+		r.frontier[ts.Node] = ts
+	}
+}
+
+// setLeaves records an operation setting the value at keys, and one for every value within it if it's a mapping
+func (r *Replica) setLeaves(keys []string, value any, ts Timestamp) {
+	mapping, ok := value.(map[string]any)
+	if !ok {
+		r.apply(replicaOp{Op: replicaSet, Keys: keys, Value: value, Time: ts})
+		return
+	}
+	if len(keys) > 0 {
+		r.apply(replicaOp{Op: replicaSet, Keys: keys, Value: map[string]any{}, Time: ts})
+	}
+	for key, child := range mapping {
+		r.setLeaves(appendPath(keys, key), child, ts)
+	}
+}
+
+// apply merges a single operation into the state, discarding whatever it supersedes
+func (r *Replica) apply(op replicaOp) {
+	pointer := formatPointer(op.Keys...)
+	if op.Op == replicaSet {
+		if existing, ok := r.entries[pointer]; ok && existing.Time.Compare(op.Time) >= 0 {
+			return
+		}
+		for idx := 0; idx <= len(op.Keys); idx++ {
+			tombstone, ok := r.tombstones[formatPointer(op.Keys[:idx]...)]
+			if ok && tombstone.Time.Compare(op.Time) > 0 {
+				return
+			}
+		}
+		r.entries[pointer] = op
+		return
+	}
+
+	if existing, ok := r.tombstones[pointer]; ok && existing.Time.Compare(op.Time) >= 0 {
+		return
+	}
+	for idx := 0; idx < len(op.Keys); idx++ {
+		tombstone, ok := r.tombstones[formatPointer(op.Keys[:idx]...)]
+		if ok && tombstone.Time.Compare(op.Time) >= 0 {
+			// An ancestor was deleted later, which covers this deletion
+			return
+		}
+	}
+	for other, entry := range r.entries {
+		if hasKeyPrefix(entry.Keys, op.Keys) && entry.Time.Compare(op.Time) < 0 {
+			delete(r.entries, other)
+		}
+	}
+	for other, tombstone := range r.tombstones {
+		if hasKeyPrefix(tombstone.Keys, op.Keys) && tombstone.Time.Compare(op.Time) <= 0 {
+			delete(r.tombstones, other)
+		}
+	}
+	r.tombstones[pointer] = op
+}
+
+// hasKeyPrefix returns true if keys lies at or beneath prefix
+func hasKeyPrefix(keys []string, prefix []string) bool {
+	if len(keys) < len(prefix) {
+		return false
+	}
+	for idx, key := range prefix {
+		if keys[idx] != key {
+			return false
+		}
+	}
+	return true
+}
+
+// copyKeys returns a copy of keys which is never nil
+func copyKeys(keys []string) []string {
+	return append([]string{}, keys...)
+}
+
+// normalizeValue returns the JSON equivalent of value, so that it's identical on every replica once imported
+func normalizeValue(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized any
+	err = json.Unmarshal(data, &normalized)
+	return normalized, err
+}
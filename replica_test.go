@@ -0,0 +1,150 @@
+package keyval
+
+import (
+	"testing"
+	"time"
+)
+
+// testReplica is a Replica whose wall clock is set by the test
+type testReplica struct {
+	*Replica
+	wall int64
+}
+
+func newTestReplica(node string) *testReplica {
+	r := &testReplica{Replica: NewReplica(node)}
+	r.now = func() time.Time {
+		return time.Unix(0, r.wall)
+	}
+	return r
+}
+
+// at sets the replica's wall clock, returning the replica
+func (r *testReplica) at(wall int64) *testReplica {
+	r.wall = wall
+	return r
+}
+
+// transfer imports into dst the operations of src which dst hasn't seen
+func transfer(t *testing.T, src *testReplica, dst *testReplica) bool {
+	t.Helper()
+	data, err := src.Export(dst.Frontier())
+	if err != nil {
+		t.Error(err)
+		return false
+	}
+	err = dst.Import(data)
+	if err != nil {
+		t.Error(err)
+		return false
+	}
+	return true
+}
+
+func TestReplicaMerge(t *testing.T) {
+	a := newTestReplica("a")
+	b := newTestReplica("b")
+	a.at(10).Set(map[string]any{"db": map[string]any{"host": "localhost", "port": 5432}, "name": "app"})
+	if !transfer(t, a, b) {
+		return
+	}
+	expectJson(t, b.Document(), `{"db":{"host":"localhost","port":5432},"name":"app"}`)
+
+	// Edit independently: different keys are all kept, and the later edit of the same key wins
+	a.at(20).Set(5433, "db", "port")
+	a.at(30).Set("a", "name")
+	b.at(25).Set("db.internal", "db", "host")
+	b.at(35).Set("b", "name")
+	b.at(40).Delete("db", "port")
+	a.at(50).Set(true, "db", "tls")
+
+	// The result doesn't depend on the order of exchange
+	c := newTestReplica("c")
+	for _, src := range []*testReplica{b, a} {
+		if !transfer(t, src, c) {
+			return
+		}
+	}
+	if !transfer(t, a, b) || !transfer(t, b, a) {
+		return
+	}
+	expected := `{"db":{"host":"db.internal","tls":true},"name":"b"}`
+	expectJson(t, a.Document(), expected)
+	expectJson(t, b.Document(), expected)
+	expectJson(t, c.Document(), expected)
+
+	// Importing again changes nothing
+	data, err := a.Export(nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = b.Import(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, b.Document(), expected)
+}
+
+func TestReplicaDelete(t *testing.T) {
+	a := newTestReplica("a")
+	b := newTestReplica("b")
+	a.at(10).Set(5432, "db", "port")
+	transfer(t, a, b)
+
+	// Edits made before a deletion are removed with it, those made after survive
+	b.at(20).Set("localhost", "db", "host")
+	a.at(30).Delete("db")
+	b.at(40).Set(true, "db", "tls")
+	transfer(t, a, b)
+	transfer(t, b, a)
+	expectJson(t, a.Document(), `{"db":{"tls":true}}`)
+	expectJson(t, b.Document(), `{"db":{"tls":true}}`)
+
+	// A value replaced by a mapping stays replaced when the mapping's content is deleted
+	a.at(50).Set(1, "mode")
+	transfer(t, a, b)
+	b.at(60).Set("fast", "mode", "speed")
+	b.at(70).Delete("mode", "speed")
+	transfer(t, b, a)
+	expectJson(t, a.Document(), `{"db":{"tls":true},"mode":{}}`)
+	expectJson(t, b.Document(), `{"db":{"tls":true},"mode":{}}`)
+}
+
+func TestReplicaClockSkew(t *testing.T) {
+	a := newTestReplica("a")
+	b := newTestReplica("b")
+	a.at(1000).Set("a", "name")
+	transfer(t, a, b)
+
+	// b's clock lags behind a's, however its edit follows the one it has seen so it wins
+	b.at(10).Set("b", "name")
+	if b.Frontier()["b"].Compare(a.Frontier()["a"]) <= 0 {
+		t.Errorf("Expected b's timestamp to follow a's")
+		return
+	}
+	transfer(t, b, a)
+	expectJson(t, a.Document(), `{"name":"b"}`)
+
+	// Only unseen operations are exported
+	data, err := a.Export(b.Frontier())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(data) != "[]" {
+		t.Errorf("Expected nothing to export, got %s", string(data))
+		return
+	}
+
+	for _, data := range []string{`{}`, `[{"op": "move", "keys": ["a"], "time": {"node": "x"}}]`, `[{"op": "set", "keys": ["a"]}]`} {
+		if a.Import([]byte(data)) == nil {
+			t.Errorf("Expected an error importing %s", data)
+			return
+		}
+	}
+	if a.Set(1) == nil || a.Delete() == nil {
+		t.Errorf("Expected errors modifying the document root")
+	}
+}
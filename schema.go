@@ -0,0 +1,492 @@
+package keyval
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxRefDepth limits how many "$ref" keywords may be followed while validating a single value
+const maxRefDepth = 256
+
+// Violation describes a single way in which a document fails to conform to a schema
+type Violation struct {
+	// Path is a JSON pointer to the offending value, the empty string refers to the document root
+	Path    string
+	Keyword string
+	Message string
+}
+
+// Error returns the violation formatted as a string
+func (v Violation) Error() string {
+	path := v.Path
+	if path == "" {
+		path = "(root)"
+	}
+	return fmt.Sprintf("%s: %s", path, v.Message)
+}
+
+// validator checks values against a JSON schema document
+type validator struct {
+	root     map[string]any
+	patterns map[string]*regexp.Regexp
+	refDepth int
+}
+
+// Validate checks the document against a JSON schema, returning every violation found.  A subset of JSON Schema
+// draft 2020-12 is supported: type, enum, const, properties, required, additionalProperties, items, minItems,
+// maxItems, minLength, maxLength, pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum, allOf, anyOf,
+// oneOf, not and local "$ref" pointers (eg. "#/$defs/thing").  Annotations such as default are ignored.  An error
+// is returned only when the schema itself is malformed.
+func (kv *KeyVal) Validate(schema *KeyVal) ([]Violation, error) {
+	v := &validator{
+		root:     schema.root,
+		patterns: map[string]*regexp.Regexp{},
+	}
+	return v.check(schema.root, kv.root, nil)
+}
+
+// check returns the violations of value against schema, where value is located at path
+func (v *validator) check(schema any, value any, path []string) ([]Violation, error) {
+	var s map[string]any
+	switch t := schema.(type) {
+	case bool:
+		if t {
+			return nil, nil
+		}
+		return []Violation{newViolation(path, "false", "No value is permitted")}, nil
+	case map[string]any:
+		s = t
+	default:
+		return nil, fmt.Errorf("Schema at \"%s\" was not an object or boolean", formatPointer(path...))
+	}
+
+	violations := []Violation{}
+	checks := []func(map[string]any, any, []string) ([]Violation, error){
+		v.checkRef,
+		v.checkType,
+		v.checkValue,
+		v.checkNumber,
+		v.checkString,
+		v.checkArray,
+		v.checkObject,
+		v.checkCombinators,
+	}
+	for _, check := range checks {
+		found, err := check(s, value, path)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, found...)
+	}
+
+	if len(violations) == 0 {
+		return nil, nil
+	}
+	return violations, nil
+}
+
+// checkRef applies the schema referred to by "$ref"
+func (v *validator) checkRef(schema map[string]any, value any, path []string) ([]Violation, error) {
+	raw, ok := schema["$ref"]
+	if !ok {
+		return nil, nil
+	}
+	target, err := resolveSchemaRef(v.root, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.refDepth >= maxRefDepth {
+		return nil, fmt.Errorf("Schema reference depth limit exceeded resolving \"%v\"", raw)
+	}
+	v.refDepth++
+	defer func() {
+		v.refDepth--
+	}()
+
+	return v.check(target, value, path)
+}
+
+// checkType applies the "type" keyword
+func (v *validator) checkType(schema map[string]any, value any, path []string) ([]Violation, error) {
+	raw, ok := schema["type"]
+	if !ok {
+		return nil, nil
+	}
+
+	var types []string
+	switch t := raw.(type) {
+	case string:
+		types = []string{t}
+	case []any:
+		for _, item := range t {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("Schema type at \"%s\" was not a string", formatPointer(path...))
+			}
+			types = append(types, name)
+		}
+	default:
+		return nil, fmt.Errorf("Schema type at \"%s\" was not a string or array", formatPointer(path...))
+	}
+
+	matched := false
+	for _, name := range types {
+		ok, err := typeMatches(name, value)
+		if err != nil {
+			return nil, fmt.Errorf("Schema type at \"%s\": %w", formatPointer(path...), err)
+		}
+		matched = matched || ok
+	}
+	if matched {
+		return nil, nil
+	}
+	return []Violation{
+		newViolation(path, "type", fmt.Sprintf("Expected %s, got %s", strings.Join(types, " or "), typeName(value))),
+	}, nil
+}
+
+// checkValue applies the "enum" and "const" keywords
+func (v *validator) checkValue(schema map[string]any, value any, path []string) ([]Violation, error) {
+	violations := []Violation{}
+	if raw, ok := schema["enum"]; ok {
+		options, ok := raw.([]any)
+		if !ok {
+			return nil, fmt.Errorf("Schema enum at \"%s\" was not an array", formatPointer(path...))
+		}
+		found := false
+		for _, option := range options {
+			if valuesEqual(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			violations = append(violations, newViolation(path, "enum", fmt.Sprintf("Value %v is not one of %v", value, options)))
+		}
+	}
+
+	if expected, ok := schema["const"]; ok && !valuesEqual(expected, value) {
+		violations = append(violations, newViolation(path, "const", fmt.Sprintf("Expected %v, got %v", expected, value)))
+	}
+
+	return violations, nil
+}
+
+// checkNumber applies the numeric range keywords
+func (v *validator) checkNumber(schema map[string]any, value any, path []string) ([]Violation, error) {
+	num, ok := asNumber(value)
+	if !ok {
+		return nil, nil
+	}
+
+	violations := []Violation{}
+	bounds := []struct {
+		keyword string
+		fails   func(num float64, limit float64) bool
+		message string
+	}{
+		{"minimum", func(n, l float64) bool { return n < l }, "Value %v is less than the minimum of %v"},
+		{"maximum", func(n, l float64) bool { return n > l }, "Value %v is greater than the maximum of %v"},
+		{"exclusiveMinimum", func(n, l float64) bool { return n <= l }, "Value %v must be greater than %v"},
+		{"exclusiveMaximum", func(n, l float64) bool { return n >= l }, "Value %v must be less than %v"},
+	}
+	for _, bound := range bounds {
+		raw, ok := schema[bound.keyword]
+		if !ok {
+			continue
+		}
+		limit, ok := asNumber(raw)
+		if !ok {
+			return nil, fmt.Errorf("Schema %s at \"%s\" was not a number", bound.keyword, formatPointer(path...))
+		}
+		if bound.fails(num, limit) {
+			violations = append(violations, newViolation(path, bound.keyword, fmt.Sprintf(bound.message, value, raw)))
+		}
+	}
+
+	return violations, nil
+}
+
+// checkString applies the string length and pattern keywords
+func (v *validator) checkString(schema map[string]any, value any, path []string) ([]Violation, error) {
+	str, ok := value.(string)
+	if !ok {
+		return nil, nil
+	}
+
+	violations, err := checkLength(schema, utf8.RuneCountInString(str), "minLength", "maxLength", "characters", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw, ok := schema["pattern"]; ok {
+		pattern, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("Schema pattern at \"%s\" was not a string", formatPointer(path...))
+		}
+		re, ok := v.patterns[pattern]
+		if !ok {
+			re, err = regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Schema pattern \"%s\" is invalid: %v", pattern, err)
+			}
+			v.patterns[pattern] = re
+		}
+		if !re.MatchString(str) {
+			violations = append(violations, newViolation(path, "pattern", fmt.Sprintf("Value \"%s\" does not match pattern \"%s\"", str, pattern)))
+		}
+	}
+
+	return violations, nil
+}
+
+// checkArray applies the array keywords
+func (v *validator) checkArray(schema map[string]any, value any, path []string) ([]Violation, error) {
+	arr, ok := value.([]any)
+	if !ok {
+		return nil, nil
+	}
+
+	violations, err := checkLength(schema, len(arr), "minItems", "maxItems", "items", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if items, ok := schema["items"]; ok {
+		for idx, item := range arr {
+			found, err := v.check(items, item, appendPath(path, fmt.Sprint(idx)))
+			if err != nil {
+				return nil, err
+			}
+			violations = append(violations, found...)
+		}
+	}
+
+	return violations, nil
+}
+
+// checkObject applies the object keywords
+func (v *validator) checkObject(schema map[string]any, value any, path []string) ([]Violation, error) {
+	obj, ok := value.(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+
+	violations := []Violation{}
+	if raw, ok := schema["required"]; ok {
+		required, ok := raw.([]any)
+		if !ok {
+			return nil, fmt.Errorf("Schema required at \"%s\" was not an array", formatPointer(path...))
+		}
+		for _, item := range required {
+			key, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("Schema required at \"%s\" contained a non-string", formatPointer(path...))
+			}
+			if _, ok := obj[key]; !ok {
+				violations = append(violations, newViolation(path, "required", fmt.Sprintf("Missing required property \"%s\"", key)))
+			}
+		}
+	}
+
+	properties := map[string]any{}
+	if raw, ok := schema["properties"]; ok {
+		properties, ok = raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("Schema properties at \"%s\" was not an object", formatPointer(path...))
+		}
+	}
+	additional, hasAdditional := schema["additionalProperties"]
+
+	for _, key := range sortedKeys(obj) {
+		propSchema, ok := properties[key]
+		if !ok {
+			if !hasAdditional {
+				continue
+			}
+			if allowed, ok := additional.(bool); ok && !allowed {
+				violations = append(violations, newViolation(path, "additionalProperties", fmt.Sprintf("Property \"%s\" is not allowed", key)))
+				continue
+			}
+			propSchema = additional
+		}
+		found, err := v.check(propSchema, obj[key], appendPath(path, key))
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, found...)
+	}
+
+	return violations, nil
+}
+
+// checkCombinators applies the allOf, anyOf, oneOf and not keywords
+func (v *validator) checkCombinators(schema map[string]any, value any, path []string) ([]Violation, error) {
+	violations := []Violation{}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		raw, ok := schema[keyword]
+		if !ok {
+			continue
+		}
+		subSchemas, ok := raw.([]any)
+		if !ok {
+			return nil, fmt.Errorf("Schema %s at \"%s\" was not an array", keyword, formatPointer(path...))
+		}
+
+		passed := 0
+		failures := []Violation{}
+		for _, subSchema := range subSchemas {
+			found, err := v.check(subSchema, value, path)
+			if err != nil {
+				return nil, err
+			}
+			if len(found) == 0 {
+				passed++
+			}
+			failures = append(failures, found...)
+		}
+
+		switch keyword {
+		case "allOf":
+			violations = append(violations, failures...)
+		case "anyOf":
+			if passed == 0 {
+				violations = append(violations, newViolation(path, keyword, "Value does not match any of the permitted schemas"))
+			}
+		case "oneOf":
+			if passed != 1 {
+				violations = append(violations, newViolation(path, keyword, fmt.Sprintf("Value must match exactly one schema, matched %d", passed)))
+			}
+		}
+	}
+
+	if notSchema, ok := schema["not"]; ok {
+		found, err := v.check(notSchema, value, path)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			violations = append(violations, newViolation(path, "not", "Value matches a disallowed schema"))
+		}
+	}
+
+	return violations, nil
+}
+
+// checkLength applies a pair of minimum/maximum count keywords against count
+func checkLength(schema map[string]any, count int, minKeyword string, maxKeyword string, unit string, path []string) ([]Violation, error) {
+	violations := []Violation{}
+	for _, keyword := range []string{minKeyword, maxKeyword} {
+		raw, ok := schema[keyword]
+		if !ok {
+			continue
+		}
+		limit, ok := asNumber(raw)
+		if !ok {
+			return nil, fmt.Errorf("Schema %s at \"%s\" was not a number", keyword, formatPointer(path...))
+		}
+		if keyword == minKeyword && float64(count) < limit {
+			violations = append(violations, newViolation(path, keyword, fmt.Sprintf("Expected at least %v %s, got %d", raw, unit, count)))
+		}
+		if keyword == maxKeyword && float64(count) > limit {
+			violations = append(violations, newViolation(path, keyword, fmt.Sprintf("Expected at most %v %s, got %d", raw, unit, count)))
+		}
+	}
+	return violations, nil
+}
+
+// resolveSchemaRef returns the schema within root referred to by the local "$ref" value raw
+func resolveSchemaRef(root map[string]any, raw any) (any, error) {
+	ref, ok := raw.(string)
+	if !ok || !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("Only local schema references are supported, got \"%v\"", raw)
+	}
+	tokens, err := parsePointer(ref[1:])
+	if err != nil {
+		return nil, err
+	}
+	target, err := pointerValue(root, tokens)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to resolve schema reference \"%s\": %v", ref, err)
+	}
+	return target, nil
+}
+
+// newViolation returns a Violation at path
+func newViolation(path []string, keyword string, message string) Violation {
+	return Violation{
+		Path:    formatPointer(path...),
+		Keyword: keyword,
+		Message: message,
+	}
+}
+
+// appendPath returns a copy of path with key appended
+func appendPath(path []string, key string) []string {
+	newPath := make([]string, len(path), len(path)+1)
+	copy(newPath, path)
+	return append(newPath, key)
+}
+
+// sortedKeys returns the keys of obj in sorted order
+func sortedKeys(obj map[string]any) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// typeMatches returns true if value is of the named JSON schema type, or an
+// error if name isn't a JSON schema type
+func typeMatches(name string, value any) (bool, error) {
+	switch name {
+	case "null":
+		return value == nil, nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok, nil
+	case "object":
+		return isMapping(value), nil
+	case "array":
+		_, ok := value.([]any)
+		return ok, nil
+	case "string":
+		_, ok := value.(string)
+		return ok, nil
+	case "number":
+		_, ok := asNumber(value)
+		return ok, nil
+	case "integer":
+		num, ok := asNumber(value)
+		return ok && num == math.Trunc(num), nil
+	default:
+		return false, fmt.Errorf("Unknown type %q", name)
+	}
+}
+
+// typeName returns the JSON schema type name of value
+func typeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	}
+	if _, ok := asNumber(value); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,157 @@
+package keyval
+
+import (
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["name", "port"],
+	"properties": {
+		"name": {"type": "string", "minLength": 2, "pattern": "^[a-z]+$"},
+		"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+		"mode": {"enum": ["dev", "prod"]},
+		"version": {"const": 2},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 3},
+		"db": {"$ref": "#/$defs/db"}
+	},
+	"additionalProperties": false,
+	"$defs": {
+		"db": {
+			"type": "object",
+			"required": ["host"],
+			"properties": {
+				"host": {"type": "string"},
+				"timeout": {"oneOf": [{"type": "integer"}, {"type": "string", "pattern": "^[0-9]+s$"}]}
+			}
+		}
+	}
+}`
+
+func TestValidateValid(t *testing.T) {
+	schema, err := NewFromJson([]byte(testSchema))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	kv, err := NewFromYaml([]byte("name: app\nport: 8080\nmode: prod\nversion: 2\ntags: [a, b]\ndb:\n  host: localhost\n  timeout: 30s\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	violations, err := kv.Validate(schema)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(violations) != 0 {
+		t.Errorf("Expected no violations, got %v", violations)
+		return
+	}
+}
+
+func TestValidateViolations(t *testing.T) {
+	schema, err := NewFromJson([]byte(testSchema))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	kv, err := NewFromJson([]byte(`{"name": "A", "port": 70000.5, "mode": "test", "version": 3, "tags": ["a", 1, "c", "d"], "db": {"timeout": true}, "extra": 1}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	violations, err := kv.Validate(schema)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := map[string]string{
+		"/name":       "minLength",
+		"/port":       "type",
+		"/mode":       "enum",
+		"/version":    "const",
+		"/tags":       "maxItems",
+		"/tags/1":     "type",
+		"/db":         "required",
+		"/db/timeout": "oneOf",
+		"":            "additionalProperties",
+	}
+	found := map[string]bool{}
+	for _, violation := range violations {
+		if expected[violation.Path] == violation.Keyword {
+			found[violation.Path] = true
+		}
+	}
+	for path, keyword := range expected {
+		if !found[path] {
+			t.Errorf("Expected %s violation at \"%s\", got %v", keyword, path, violations)
+		}
+	}
+}
+
+func TestValidateCombinators(t *testing.T) {
+	schema, err := NewFromJson([]byte(`{"properties": {
+		"a": {"anyOf": [{"type": "string"}, {"type": "null"}]},
+		"b": {"allOf": [{"minimum": 1}, {"exclusiveMaximum": 5}]},
+		"c": {"not": {"type": "boolean"}}
+	}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	kv, err := NewFromJson([]byte(`{"a": null, "b": 4, "c": "x"}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	violations, err := kv.Validate(schema)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(violations) != 0 {
+		t.Errorf("Expected no violations, got %v", violations)
+		return
+	}
+
+	kv, err = NewFromJson([]byte(`{"a": 1, "b": 5, "c": false}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	violations, err = kv.Validate(schema)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(violations) != 3 {
+		t.Errorf("Expected 3 violations, got %v", violations)
+		return
+	}
+}
+
+func TestValidateBadSchema(t *testing.T) {
+	schema, err := NewFromJson([]byte(`{"properties": {"a": {"pattern": "("}, "b": {"$ref": "#/$defs/missing"}, "c": {"type": "strin"}, "d": {"type": ["integer", "numbr"]}}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, source := range []string{`{"a": "x"}`, `{"b": 1}`, `{"c": "x"}`, `{"d": 1}`} {
+		kv, err := NewFromJson([]byte(source))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, err = kv.Validate(schema)
+		if err == nil {
+			t.Errorf("Expected malformed schema error for %s", source)
+			return
+		}
+	}
+}
@@ -0,0 +1,394 @@
+package keyval
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	secretPrefix = "ENC[AES256_GCM,"
+	secretSuffix = "]"
+	// secretKeySize is the size of an AES-256 key
+	secretKeySize = 32
+)
+
+// ErrNoKeyProvider is returned when encrypting or decrypting secrets without a key provider configured
+var ErrNoKeyProvider = errors.New("No key provider is configured")
+
+// KeyProvider supplies the keys used to encrypt and decrypt secrets.  Keys are 32 bytes long, and identified by a
+// string which is stored alongside each secret, so that secrets encrypted with an older key can still be decrypted
+// after the current key is rotated.
+type KeyProvider interface {
+	// EncryptionKey returns the current key, used to encrypt new secrets, along with its identifier
+	EncryptionKey() (id string, key []byte, err error)
+	// DecryptionKey returns the key with the given identifier
+	DecryptionKey(id string) ([]byte, error)
+}
+
+// KeyRing is a KeyProvider holding local keys, such as ones read from files kept out of version control
+type KeyRing struct {
+	// Current identifies the key used to encrypt new secrets
+	Current string
+	// Keys maps identifiers to keys, including retired keys which secrets may still be encrypted with
+	Keys map[string][]byte
+}
+
+// EncryptionKey returns the current key along with its identifier
+func (r *KeyRing) EncryptionKey() (string, []byte, error) {
+	key, err := r.DecryptionKey(r.Current)
+	return r.Current, key, err
+}
+
+// DecryptionKey returns the key with the given identifier
+func (r *KeyRing) DecryptionKey(id string) ([]byte, error) {
+	key, ok := r.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("Key \"%s\" does not exist", id)
+	}
+	return key, nil
+}
+
+// GenerateKey returns a new random key suitable for a KeyRing
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, secretKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// IsSecret returns true if value is an encrypted secret, a string of the form
+// ENC[AES256_GCM,data:...,iv:...,tag:...,type:...,key:...]
+func IsSecret(value any) bool {
+	s, ok := value.(string)
+	return ok && strings.HasPrefix(s, secretPrefix) && strings.HasSuffix(s, secretSuffix)
+}
+
+// SetKeyProvider configures the provider of the keys used to encrypt and decrypt secrets.  Once configured, the
+// getters (Value, String, Number, Boolean, Array, Mapping, ResolveValue and Decode) and interpolated references
+// transparently decrypt any secrets they return, while ToJson and ToYaml keep them encrypted, so the document can be
+// stored safely.  Only the secrets within the value returned are decrypted, and since decrypting produces new values,
+// the mappings and arrays holding them are returned as copies, while any others are returned as they are stored.  The
+// provider carries over to copies, sub-KeyVals, transaction drafts, and the results of Persistent and Resolve.  A nil
+// provider disables decryption.
+func (kv *KeyVal) SetKeyProvider(provider KeyProvider) {
+	kv.keyProvider = provider
+}
+
+// Encrypt replaces the values at paths with secrets encrypted using the provider's current key.  Each path is split
+// into keys with SplitKey, and the empty string refers to the whole document.  Every string, number and boolean
+// within a mapping or array is encrypted individually, leaving the structure and keys readable.  Values which are
+// already secrets, and nulls, are left as they are.  If any path can't be encrypted, nothing is modified.  A secret
+// isn't bound to its location, so it may be moved, included from another file or stacked as part of a layer, however
+// it is bound to its type, so a secret whose type is altered fails to decrypt.
+func (kv *KeyVal) Encrypt(paths ...string) error {
+	if kv.frozen {
+		return ErrFrozen
+	}
+	if kv.keyProvider == nil {
+		return ErrNoKeyProvider
+	}
+	id, key, err := kv.keyProvider.EncryptionKey()
+	if err != nil {
+		return err
+	}
+
+	type target struct {
+		keys  []string
+		value any
+	}
+	targets := []target{}
+	for _, path := range paths {
+		keys := []string{}
+		if path != "" {
+			keys = SplitKey(path)
+		}
+		v, err := kv.lookup(keys...)
+		if err != nil {
+			return fmt.Errorf("Unable to encrypt \"%s\": %v", path, err)
+		}
+		v, err = mapSecrets(v, func(v any) (any, error) {
+			if v == nil || IsSecret(v) {
+				return v, nil
+			}
+			return encryptSecret(v, id, key)
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to encrypt \"%s\": %v", path, err)
+		}
+		targets = append(targets, target{keys, v})
+	}
+
+	// Only the mappings holding the encrypted values are modified in place
+	within := map[string]any{}
+	for _, t := range targets {
+		obj := within
+		for idx := 0; idx < len(t.keys)-1; idx++ {
+			child, ok := obj[t.keys[idx]].(map[string]any)
+			if !ok {
+				child = map[string]any{}
+				obj[t.keys[idx]] = child
+			}
+			obj = child
+		}
+	}
+
+	return kv.notifyDocument(within, func() error {
+		for _, t := range targets {
+			if len(t.keys) == 0 {
+				kv.root = t.value.(map[string]any)
+				kv.setOwnership(fullOwnership())
+			} else {
+				parent, owned, err := kv.walk(false, t.keys[:len(t.keys)-1]...)
+				if err != nil {
+					return err
+				}
+				parent[t.keys[len(t.keys)-1]] = t.value
+				owned.claim(t.keys[len(t.keys)-1])
+			}
+		}
+		return nil
+	})
+}
+
+// RotateKey re-encrypts every secret within the document with the provider's current key, so that the keys
+// previously used may be retired
+func (kv *KeyVal) RotateKey() error {
+	if kv.frozen {
+		return ErrFrozen
+	}
+	if kv.keyProvider == nil {
+		return ErrNoKeyProvider
+	}
+	id, key, err := kv.keyProvider.EncryptionKey()
+	if err != nil {
+		return err
+	}
+	root, err := mapSecrets(kv.root, func(v any) (any, error) {
+		if !IsSecret(v) {
+			return v, nil
+		}
+		plain, err := kv.decryptSecret(v.(string))
+		if err != nil {
+			return nil, err
+		}
+		return encryptSecret(plain, id, key)
+	})
+	if err != nil {
+		return err
+	}
+
+	return kv.notifyDocument(map[string]any{}, func() error {
+		kv.root = root.(map[string]any)
+		kv.setOwnership(fullOwnership())
+		return nil
+	})
+}
+
+// reveal returns v, located at keys, with every secret within it decrypted.  Only the mappings and arrays holding
+// secrets are copied, so values without secrets are returned as they are stored, as they are if no key provider is
+// configured.
+func (kv *KeyVal) reveal(keys []string, v any) (any, error) {
+	if kv.keyProvider == nil {
+		return v, nil
+	}
+	revealed, _, err := kv.decryptSecrets(keys, v)
+	return revealed, err
+}
+
+// decryptSecrets returns v, located at path, with every secret within it decrypted, and whether it held any
+func (kv *KeyVal) decryptSecrets(path []string, v any) (any, bool, error) {
+	switch t := v.(type) {
+	case map[string]any:
+		var result map[string]any
+		for key, val := range t {
+			revealed, found, err := kv.decryptSecrets(appendPath(path, key), val)
+			if err != nil {
+				return nil, false, err
+			}
+			if !found {
+				continue
+			}
+			if result == nil {
+				result = make(map[string]any, len(t))
+				for key, val := range t {
+					result[key] = val
+				}
+			}
+			result[key] = revealed
+		}
+		if result == nil {
+			return t, false, nil
+		}
+		return result, true, nil
+	case []any:
+		var result []any
+		for idx, val := range t {
+			revealed, found, err := kv.decryptSecrets(appendPath(path, strconv.Itoa(idx)), val)
+			if err != nil {
+				return nil, false, err
+			}
+			if !found {
+				continue
+			}
+			if result == nil {
+				result = append([]any{}, t...)
+			}
+			result[idx] = revealed
+		}
+		if result == nil {
+			return t, false, nil
+		}
+		return result, true, nil
+	default:
+		if !IsSecret(v) {
+			return v, false, nil
+		}
+		plain, err := kv.decryptSecret(v.(string))
+		if err != nil {
+			return nil, false, fmt.Errorf("Unable to decrypt \"%s\": %v", formatPointer(path...), err)
+		}
+		return plain, true, nil
+	}
+}
+
+// mapSecrets returns a copy of v with every scalar within it replaced by the result of fn
+func mapSecrets(v any, fn func(v any) (any, error)) (any, error) {
+	switch t := v.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(t))
+		for key, val := range t {
+			mapped, err := mapSecrets(val, fn)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = mapped
+		}
+		return result, nil
+	case []any:
+		result := make([]any, len(t))
+		for idx, val := range t {
+			mapped, err := mapSecrets(val, fn)
+			if err != nil {
+				return nil, err
+			}
+			result[idx] = mapped
+		}
+		return result, nil
+	default:
+		return fn(v)
+	}
+}
+
+// encryptSecret encrypts a scalar value with key, identified by id
+func encryptSecret(v any, id string, key []byte) (string, error) {
+	if strings.ContainsAny(id, ",]") {
+		return "", fmt.Errorf("Key identifier \"%s\" may not contain ',' or ']'", id)
+	}
+	var plain, typ string
+	switch t := v.(type) {
+	case string:
+		plain, typ = t, "str"
+	case float64:
+		plain, typ = strconv.FormatFloat(t, 'g', -1, 64), "float"
+	case int:
+		plain, typ = strconv.Itoa(t), "int"
+	case bool:
+		plain, typ = strconv.FormatBool(t), "bool"
+	default:
+		return "", fmt.Errorf("Values of type %s can't be encrypted", typeName(v))
+	}
+
+	gcm, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(iv)
+	if err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(plain), secretData(typ))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	encode := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("%sdata:%s,iv:%s,tag:%s,type:%s,key:%s%s", secretPrefix, encode(data), encode(iv),
+		encode(tag), typ, id, secretSuffix), nil
+}
+
+// decryptSecret decrypts a secret with the key it names, restoring the value's original type
+func (kv *KeyVal) decryptSecret(secret string) (any, error) {
+	if kv.keyProvider == nil {
+		return nil, ErrNoKeyProvider
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(secret[len(secretPrefix):len(secret)-len(secretSuffix)], ",") {
+		name, value, ok := strings.Cut(field, ":")
+		if !ok {
+			return nil, fmt.Errorf("Secret is malformed")
+		}
+		fields[name] = value
+	}
+
+	key, err := kv.keyProvider.DecryptionKey(fields["key"])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newSecretCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	decoded := map[string][]byte{}
+	for _, name := range []string{"data", "iv", "tag"} {
+		decoded[name], err = base64.StdEncoding.DecodeString(fields[name])
+		if err != nil {
+			return nil, fmt.Errorf("Secret has an invalid %s: %v", name, err)
+		}
+	}
+	if len(decoded["iv"]) != gcm.NonceSize() {
+		return nil, fmt.Errorf("Secret has an invalid iv")
+	}
+	plain, err := gcm.Open(nil, decoded["iv"], append(decoded["data"], decoded["tag"]...),
+		secretData(fields["type"]))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt secret with key \"%s\": %v", fields["key"], err)
+	}
+
+	switch fields["type"] {
+	case "str":
+		return string(plain), nil
+	case "float":
+		return strconv.ParseFloat(string(plain), 64)
+	case "int":
+		return strconv.Atoi(string(plain))
+	case "bool":
+		return strconv.ParseBool(string(plain))
+	default:
+		return nil, fmt.Errorf("Secret has an unknown type \"%s\"", fields["type"])
+	}
+}
+
+// secretData returns the additional data authenticated along with a secret of type typ, binding the secret to its
+// type.  Secrets aren't bound to their location, which depends on the file or layer holding them.
+func secretData(typ string) []byte {
+	return []byte(typ)
+}
+
+// newSecretCipher returns an AES-256-GCM cipher using key
+func newSecretCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != secretKeySize {
+		return nil, fmt.Errorf("Key must be %d bytes long, got %d", secretKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
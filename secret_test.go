@@ -0,0 +1,283 @@
+package keyval
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// newTestKeyRing returns a KeyRing holding the named keys, the first being current
+func newTestKeyRing(t *testing.T, ids ...string) *KeyRing {
+	ring := &KeyRing{
+		Current: ids[0],
+		Keys:    map[string][]byte{},
+	}
+	for _, id := range ids {
+		key, err := GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ring.Keys[id] = key
+	}
+	return ring
+}
+
+func TestEncrypt(t *testing.T) {
+	kv, err := NewFromYaml([]byte("db:\n  host: localhost\n  password: hunter2\n  port: 5432\napi:\n  tokens: [a, b]\n  enabled: true\n  ratio: 0.5\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if kv.Encrypt("db.password") != ErrNoKeyProvider {
+		t.Errorf("Expected ErrNoKeyProvider")
+		return
+	}
+	kv.SetKeyProvider(newTestKeyRing(t, "k1"))
+	err = kv.Encrypt("db.password", "db.port", "api")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Secrets are stored encrypted and read back decrypted with their original types
+	data, err := kv.ToYaml()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if strings.Contains(string(data), "hunter2") || strings.Count(string(data), "ENC[AES256_GCM,") != 6 {
+		t.Errorf("Expected 6 secrets, got:\n%s", string(data))
+		return
+	}
+	raw, _ := kv.lookup("db", "password")
+	if !IsSecret(raw) || !strings.HasSuffix(raw.(string), ",type:str,key:k1]") {
+		t.Errorf("Unexpected secret %v", raw)
+		return
+	}
+	password, err := kv.String("db", "password")
+	if err != nil || password != "hunter2" {
+		t.Errorf("Expected hunter2, got %q (%v)", password, err)
+		return
+	}
+	api, err := kv.Mapping("api")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, NewFromMap(api), `{"enabled":true,"ratio":0.5,"tokens":["a","b"]}`)
+
+	var config struct {
+		Db struct {
+			Host string `json:"host"`
+			Port int    `json:"port"`
+		} `json:"db"`
+	}
+	err = kv.Decode(&config)
+	if err != nil || config.Db.Host != "localhost" || config.Db.Port != 5432 {
+		t.Errorf("Unexpected decoded value %+v (%v)", config, err)
+		return
+	}
+
+	// Without the key provider, secrets are returned as they are stored
+	copied := kv.Copy()
+	copied.SetKeyProvider(nil)
+	v, err := copied.Value("db", "password")
+	if err != nil || !IsSecret(v) {
+		t.Errorf("Expected the encrypted secret, got %v", v)
+		return
+	}
+
+	if kv.Encrypt("db.missing") == nil {
+		t.Errorf("Expected an error encrypting a missing value")
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	ring := newTestKeyRing(t, "old", "new")
+	kv := NewFromMap(map[string]any{"password": "hunter2", "user": "admin"})
+	kv.SetKeyProvider(ring)
+	err := kv.Encrypt("password")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	before, _ := kv.lookup("password")
+
+	ring.Current = "new"
+	err = kv.RotateKey()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	after, _ := kv.lookup("password")
+	if after == before || !strings.HasSuffix(after.(string), ",key:new]") {
+		t.Errorf("Expected the secret to be encrypted with the new key, got %v", after)
+		return
+	}
+
+	// The old key can now be retired
+	delete(ring.Keys, "old")
+	password, err := kv.String("password")
+	if err != nil || password != "hunter2" {
+		t.Errorf("Expected hunter2, got %q (%v)", password, err)
+		return
+	}
+
+	// A tampered secret fails to decrypt
+	tampered := strings.Replace(after.(string), "data:", "data:AA", 1)
+	kv.SetValue(tampered, "password")
+	_, err = kv.String("password")
+	if err == nil {
+		t.Errorf("Expected an error decrypting a tampered secret")
+	}
+}
+
+func TestSecretLocation(t *testing.T) {
+	kv, err := NewFromYaml([]byte("db:\n  password: hunter2\n  hosts: [a, b]\n  url: postgres://admin:${db.password}@db\nname: app\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	kv.SetKeyProvider(newTestKeyRing(t, "k1"))
+	err = kv.Encrypt("db.password", "db.hosts")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Sub-KeyVals, persistent copies and resolved copies decrypt secrets, and references to secrets are decrypted
+	sub, err := kv.GetKeyVal("db")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	persistent, err := kv.Persistent().GetKeyVal("db")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resolved, err := kv.Resolve()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for name, test := range map[string]struct {
+		kv   *KeyVal
+		keys []string
+	}{
+		"sub":        {sub, []string{"password"}},
+		"persistent": {persistent, []string{"password"}},
+		"resolved":   {resolved, []string{"db", "password"}},
+	} {
+		password, err := test.kv.String(test.keys...)
+		if err != nil || password != "hunter2" {
+			t.Errorf("%s: Expected hunter2, got %q (%v)", name, password, err)
+			return
+		}
+	}
+	url, err := resolved.String("db", "url")
+	if err != nil || url != "postgres://admin:hunter2@db" {
+		t.Errorf("Expected the interpolated password to be decrypted, got %q (%v)", url, err)
+		return
+	}
+	hosts, err := sub.Array("hosts")
+	if err != nil || len(hosts) != 2 || hosts[1] != "b" {
+		t.Errorf("Expected the hosts to be decrypted, got %v (%v)", hosts, err)
+		return
+	}
+
+	// A secret may be moved, however one with another type fails to decrypt
+	raw, _ := kv.lookup("db", "password")
+	kv.SetValue(raw, "name")
+	name, err := kv.String("name")
+	if err != nil || name != "hunter2" {
+		t.Errorf("Expected a moved secret to decrypt, got %q (%v)", name, err)
+		return
+	}
+	kv.SetValue(strings.Replace(raw.(string), "type:str", "type:bool", 1), "db", "password")
+	_, err = kv.Value("db", "password")
+	if err == nil {
+		t.Errorf("Expected an error decrypting a secret with a modified type")
+		return
+	}
+
+	// Only the secrets returned are decrypted, and only the mappings holding them are copied
+	kv.SetValue(map[string]any{"plain": "text"}, "other")
+	other, err := kv.Mapping("other")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	other["added"] = true
+	if _, err := kv.Value("other", "added"); err != nil {
+		t.Errorf("Expected a mapping without secrets to be returned as it is stored")
+		return
+	}
+	kv.SetValue(raw, "db", "password")
+	db, err := kv.Mapping("db")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	db["added"] = true
+	if _, err := kv.Value("db", "added"); err == nil {
+		t.Errorf("Expected a mapping holding secrets to be copied")
+		return
+	}
+}
+
+func TestSecretSources(t *testing.T) {
+	ring := newTestKeyRing(t, "k1")
+	secrets := NewFromMap(map[string]any{"password": "hunter2", "port": 5432})
+	secrets.SetKeyProvider(ring)
+	err := secrets.Encrypt("password", "port")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := secrets.ToYaml()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Secrets committed to one file decrypt when included from another, or stacked as part of a layer
+	fsys := fstest.MapFS{
+		"main.yaml":    {Data: []byte("name: app\ndb: !include secrets.yaml\nport: !include secrets.yaml#/port\n")},
+		"secrets.yaml": {Data: data},
+	}
+	included, err := NewFromFile(fsys, "main.yaml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	included.SetKeyProvider(ring)
+	base := NewFromMap(map[string]any{"db": map[string]any{"host": "localhost"}})
+	base.SetKeyProvider(ring)
+	stacked := base.Stack(NewFromMap(map[string]any{"db": secrets.Copy().root}))
+	for name, test := range map[string]struct {
+		kv   *KeyVal
+		keys []string
+	}{
+		"included":         {included, []string{"db", "password"}},
+		"included pointer": {included, []string{"port"}},
+		"stacked":          {stacked, []string{"db", "password"}},
+	} {
+		v, err := test.kv.Value(test.keys...)
+		if err != nil || (v != "hunter2" && v != 5432) {
+			t.Errorf("%s: Expected the secret to decrypt, got %v (%v)", name, v, err)
+			return
+		}
+	}
+
+	// A secret which can't be decrypted only affects reads of values holding it
+	delete(ring.Keys, "k1")
+	if _, err := included.String("name"); err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = included.Mapping("db")
+	if err == nil || !strings.Contains(err.Error(), "\"/db/") {
+		t.Errorf("Expected an error naming the secret, got %v", err)
+	}
+}
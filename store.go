@@ -0,0 +1,77 @@
+package keyval
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Store holds an immutable KeyVal which readers may load without locking while a writer prepares the next version.
+// Each update produces a new frozen KeyVal sharing every mapping it didn't modify with the previous version, so
+// updates cost time proportional to the paths they modify rather than the size of the document.
+type Store struct {
+	current atomic.Pointer[storeVersion]
+	lock    sync.Mutex
+}
+
+// storeVersion pairs a frozen KeyVal with its version number
+type storeVersion struct {
+	kv      *KeyVal
+	version uint64
+}
+
+// NewStore returns a new Store whose initial version (version 1) is a copy of kv.  If kv is nil, the initial version
+// is empty.
+func NewStore(kv *KeyVal) *Store {
+	if kv == nil {
+		kv = New()
+	}
+	initial := kv.Persistent()
+	initial.freeze()
+
+	s := &Store{}
+	s.current.Store(&storeVersion{
+		kv:      initial,
+		version: 1,
+	})
+	return s
+}
+
+// Load returns the current version of the document.  The returned KeyVal is frozen and must not be modified, including
+// any mappings or arrays obtained from it.
+func (s *Store) Load() *KeyVal {
+	return s.current.Load().kv
+}
+
+// LoadVersion returns the current version of the document along with its version number
+func (s *Store) LoadVersion() (*KeyVal, uint64) {
+	current := s.current.Load()
+	return current.kv, current.version
+}
+
+// Version returns the current version number
+func (s *Store) Version() uint64 {
+	return s.current.Load().version
+}
+
+// Update calls fn with a draft of the next version, publishing it as the current version if fn returns without
+// error.  The draft may be modified through SetValue, CreateValue and other KeyVal methods, but mappings and arrays
+// obtained from its getters are shared with earlier versions and must not be modified directly.  Updates are
+// serialized with respect to each other, and never block readers.
+func (s *Store) Update(fn func(kv *KeyVal) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current := s.current.Load()
+	draft := current.kv.Copy()
+	err := fn(draft)
+	if err != nil {
+		return err
+	}
+
+	draft.freeze()
+	s.current.Store(&storeVersion{
+		kv:      draft,
+		version: current.version + 1,
+	})
+	return nil
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"sort"
+)
+
+const (
+	// headerSize is the size of the header preceding each record, holding the payload length and checksum
+	headerSize = 8
+	// maxRecordSize bounds the payload length, so that a corrupt header isn't mistaken for a huge record
+	maxRecordSize = 1 << 30
+
+	opSet    = "set"
+	opDelete = "delete"
+)
+
+var (
+	// castagnoli is the CRC-32C table used to checksum records
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+	// errTorn indicates a record at the end of the input which was only partially written, or which fails its
+	// checksum
+	errTorn = errors.New("Torn or corrupt record")
+	// errCorrupt indicates a record which fails its checksum, followed by further input, so it wasn't torn by a crash
+	errCorrupt = errors.New("Corrupt record")
+)
+
+// operation is a single modification within a log record
+type operation struct {
+	Op    string   `json:"op"`
+	Keys  []string `json:"keys"`
+	Value any      `json:"value"`
+}
+
+// logRecord is a batch of operations applied atomically, numbered consecutively from 1
+type logRecord struct {
+	Seq uint64      `json:"seq"`
+	Ops []operation `json:"ops"`
+}
+
+// snapshotRecord holds the document as of a log sequence number
+type snapshotRecord struct {
+	Seq  uint64         `json:"seq"`
+	Data map[string]any `json:"data"`
+}
+
+// encodeRecord returns v framed with its length and checksum
+func encodeRecord(v any) ([]byte, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > maxRecordSize {
+		return nil, fmt.Errorf("Record of %d bytes exceeds the maximum size", len(payload))
+	}
+
+	frame := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(payload, castagnoli))
+	copy(frame[headerSize:], payload)
+	return frame, nil
+}
+
+// recordReader reads framed records, tracking the offset following the last complete record
+type recordReader struct {
+	r      *bufio.Reader
+	offset int64
+	size   int64
+}
+
+// newRecordReader returns a recordReader over r, which holds size bytes
+func newRecordReader(r io.Reader, size int64) *recordReader {
+	return &recordReader{
+		r:    bufio.NewReader(r),
+		size: size,
+	}
+}
+
+// next decodes the next record into v.  io.EOF is returned at a clean end of input, errTorn if the remaining input
+// doesn't hold a complete and intact record, and errCorrupt if a record is damaged but followed by further input.
+func (rr *recordReader) next(v any) error {
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(rr.r, header)
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		if n > 0 && err == io.ErrUnexpectedEOF {
+			return errTorn
+		}
+		return err
+	}
+
+	// A length beyond the remaining input, whether written partially or damaged, is never allocated
+	length := binary.BigEndian.Uint32(header[0:4])
+	end := rr.offset + int64(headerSize) + int64(length)
+	if length > maxRecordSize || end > rr.size {
+		return errTorn
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(rr.r, payload)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errTorn
+	}
+	if err != nil {
+		return err
+	}
+	if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(header[4:8]) || json.Unmarshal(payload, v) != nil {
+		if end < rr.size {
+			return errCorrupt
+		}
+		return errTorn
+	}
+
+	rr.offset = end
+	return nil
+}
+
+// diffOps appends the operations transforming mapping a into mapping b, located at path.  Mappings are compared key
+// by key, any other changed value (including an array) is replaced as a whole.
+func diffOps(path []string, a map[string]any, b map[string]any, ops []operation) []operation {
+	if reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer() {
+		// Persistent KeyVals share every mapping which wasn't modified
+		return ops
+	}
+
+	for _, key := range sortedKeys(a) {
+		if _, ok := b[key]; !ok {
+			ops = append(ops, operation{
+				Op:   opDelete,
+				Keys: appendKey(path, key),
+			})
+		}
+	}
+	for _, key := range sortedKeys(b) {
+		oldVal, ok := a[key]
+		newVal := b[key]
+		if ok {
+			oldMap, oldOk := oldVal.(map[string]any)
+			newMap, newOk := newVal.(map[string]any)
+			if oldOk && newOk {
+				ops = diffOps(appendKey(path, key), oldMap, newMap, ops)
+				continue
+			}
+			if reflect.DeepEqual(oldVal, newVal) {
+				continue
+			}
+		}
+		ops = append(ops, operation{
+			Op:    opSet,
+			Keys:  appendKey(path, key),
+			Value: newVal,
+		})
+	}
+	return ops
+}
+
+// sortedKeys returns the keys of obj in sorted order
+func sortedKeys(obj map[string]any) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// appendKey returns a new slice holding path followed by key
+func appendKey(path []string, key string) []string {
+	keys := make([]string, len(path), len(path)+1)
+	copy(keys, path)
+	return append(keys, key)
+}
@@ -0,0 +1,480 @@
+// Package store persists a KeyVal to disk, making it suitable as the embedded state store of a small daemon.  Rather
+// than rewriting the whole document on every change, each update is appended to a log as a checksummed record of the
+// operations it performed.  The log is periodically compacted into a snapshot of the document, and on opening, the
+// snapshot is loaded and the log replayed, discarding any record left incomplete by a crash.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashibuto/keyval"
+)
+
+const (
+	// DefaultSyncInterval is the interval used by SyncInterval when Options doesn't specify one
+	DefaultSyncInterval = time.Second
+	// DefaultCompactRecords is the log length triggering compaction when Options doesn't specify one
+	DefaultCompactRecords = 1000
+
+	snapshotName = "snapshot"
+	logName      = "log"
+)
+
+// ErrClosed is returned when using a Store which has been closed
+var ErrClosed = errors.New("Store is closed")
+
+// SyncPolicy determines when appended records are flushed to stable storage with fsync
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes every record before the update returns, so no acknowledged update is lost to a crash
+	SyncAlways SyncPolicy = iota
+	// SyncInterval flushes records in the background every Options.SyncInterval, so a crash loses at most that
+	// interval's worth of updates
+	SyncInterval
+	// SyncNever leaves flushing to the operating system, so a power failure may lose any number of recent updates
+	SyncNever
+)
+
+// Options controls the behavior of a Store
+type Options struct {
+	// Sync determines when records are flushed to stable storage, SyncAlways by default
+	Sync SyncPolicy
+	// SyncInterval is the flush interval used by SyncInterval, DefaultSyncInterval if zero
+	SyncInterval time.Duration
+	// CompactRecords is the number of records the log may hold before it is compacted into a snapshot,
+	// DefaultCompactRecords if zero.  A negative value disables automatic compaction.
+	CompactRecords int
+	// OnError is called when a background flush or an automatic compaction fails
+	OnError func(err error)
+}
+
+// Store is a KeyVal persisted to a directory, holding a snapshot file and a log file.  Reads never block, as with
+// keyval.Store, while updates are serialized and written to the log before they become visible.  A directory must
+// only be opened by one Store at a time.
+type Store struct {
+	dir     string
+	opts    Options
+	mem     *keyval.Store
+	lock    sync.Mutex
+	log     *os.File
+	size    int64
+	seq     uint64
+	records int
+	dirty   bool
+	closed  bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Open opens the Store within dir, creating the directory if necessary.  The document is recovered from the snapshot
+// and log.  A record at the end of the log which was torn by a crash is discarded, however an error is returned if a
+// damaged record is followed by others, rather than discarding them.
+func Open(dir string, opts Options) (*Store, error) {
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if opts.CompactRecords == 0 {
+		opts.CompactRecords = DefaultCompactRecords
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:  dir,
+		opts: opts,
+	}
+
+	kv, err := s.recover()
+	if err != nil {
+		if s.log != nil {
+			s.log.Close()
+		}
+		return nil, err
+	}
+	s.mem = keyval.NewStore(kv)
+
+	if opts.Sync == SyncInterval {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+// Load returns the current document, which is frozen and must not be modified
+func (s *Store) Load() *keyval.KeyVal {
+	return s.mem.Load()
+}
+
+// Update calls fn with a draft of the document, as with keyval.Store.  If fn returns without error, the changes it
+// made are appended to the log and then published.  If the log can't be written, the changes are discarded and the
+// error returned.  The log is compacted once it reaches Options.CompactRecords records.  Values set within the draft
+// are persisted as JSON, so they are read back as their JSON equivalent.
+func (s *Store) Update(fn func(kv *keyval.KeyVal) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+
+	err := s.mem.Update(func(draft *keyval.KeyVal) error {
+		before, err := s.mem.Load().Mapping()
+		if err != nil {
+			return err
+		}
+		err = fn(draft)
+		if err != nil {
+			return err
+		}
+		after, err := draft.Mapping()
+		if err != nil {
+			return err
+		}
+
+		ops := diffOps(nil, before, after, []operation{})
+		if len(ops) == 0 {
+			return nil
+		}
+		return s.append(logRecord{
+			Seq: s.seq + 1,
+			Ops: ops,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.opts.CompactRecords > 0 && s.records >= s.opts.CompactRecords {
+		// The update is already durable, so a failed compaction is only reported, and retried after the next update
+		err = s.compact()
+		if err != nil {
+			s.reportError(err)
+		}
+	}
+	return nil
+}
+
+// Set sets the value at keys, creating any missing parents
+func (s *Store) Set(value any, keys ...string) error {
+	return s.Update(func(kv *keyval.KeyVal) error {
+		return kv.CreateValue(value, keys...)
+	})
+}
+
+// Delete removes the value at keys
+func (s *Store) Delete(keys ...string) error {
+	return s.Update(func(kv *keyval.KeyVal) error {
+		return kv.DeleteValue(keys...)
+	})
+}
+
+// Compact writes the current document to the snapshot file and empties the log
+func (s *Store) Compact() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	return s.compact()
+}
+
+// Sync flushes any records not yet written to stable storage
+func (s *Store) Sync() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	return s.sync()
+}
+
+// Close flushes the log and closes the Store
+func (s *Store) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return ErrClosed
+	}
+	s.closed = true
+	err := s.sync()
+	closeErr := s.log.Close()
+	s.lock.Unlock()
+
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// recover loads the snapshot, replays the log atop it and opens the log for appending
+func (s *Store) recover() (*keyval.KeyVal, error) {
+	kv := keyval.New()
+	snapshot, err := s.readSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot != nil {
+		kv = keyval.NewFromMap(snapshot.Data)
+		s.seq = snapshot.Seq
+	}
+
+	s.log, err = os.OpenFile(filepath.Join(s.dir, logName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := s.log.Stat()
+	if err != nil {
+		return nil, err
+	}
+	reader := newRecordReader(s.log, info.Size())
+	var end int64
+	for {
+		var record logRecord
+		err := reader.next(&record)
+		if err == io.EOF || err == errTorn {
+			break
+		}
+		if err == errCorrupt {
+			return nil, fmt.Errorf("Log is corrupt at offset %d", reader.offset)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record.Seq <= s.seq {
+			// Already included in the snapshot, the log not having been emptied before a crash
+			end = reader.offset
+			continue
+		}
+		if record.Seq != s.seq+1 {
+			if reader.offset < info.Size() {
+				return nil, fmt.Errorf("Log record %d follows record %d", record.Seq, s.seq)
+			}
+			// A gap at the end of the log can only be left by a torn write, so treat it as the end of the log
+			break
+		}
+		err = replay(kv, record.Ops)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to replay record %d: %v", record.Seq, err)
+		}
+		s.seq = record.Seq
+		s.records++
+		end = reader.offset
+	}
+
+	// Discard anything following the last intact record, so that new records are appended after it
+	if info.Size() != end {
+		err = s.log.Truncate(end)
+		if err == nil {
+			err = s.log.Sync()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = s.log.Seek(end, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	s.size = end
+	return kv, nil
+}
+
+// readSnapshot returns the snapshot, or nil if there isn't one
+func (s *Store) readSnapshot() (*snapshotRecord, error) {
+	f, err := os.Open(filepath.Join(s.dir, snapshotName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot snapshotRecord
+	err = newRecordReader(f, info.Size()).next(&snapshot)
+	if err == io.EOF || err == errTorn || err == errCorrupt {
+		// The snapshot is replaced atomically, so it can't be torn by a crash
+		return nil, fmt.Errorf("Snapshot file is corrupt")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// replay applies the operations of a log record to kv
+func replay(kv *keyval.KeyVal, ops []operation) error {
+	for _, op := range ops {
+		var err error
+		switch op.Op {
+		case opSet:
+			err = kv.CreateValue(op.Value, op.Keys...)
+		case opDelete:
+			err = kv.DeleteValue(op.Keys...)
+		default:
+			err = fmt.Errorf("Unknown operation \"%s\"", op.Op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// append writes record to the log, flushing it according to the sync policy
+func (s *Store) append(record logRecord) error {
+	frame, err := encodeRecord(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.log.Write(frame)
+	if err != nil {
+		// Remove any partial record so that later records aren't appended after it
+		s.rewind()
+		return err
+	}
+	s.dirty = true
+	if s.opts.Sync == SyncAlways {
+		err = s.sync()
+		if err != nil {
+			s.rewind()
+			return err
+		}
+	}
+
+	s.size += int64(len(frame))
+	s.seq = record.Seq
+	s.records++
+	return nil
+}
+
+// rewind truncates the log to the end of the last record successfully appended
+func (s *Store) rewind() {
+	err := s.log.Truncate(s.size)
+	if err == nil {
+		_, err = s.log.Seek(s.size, io.SeekStart)
+	}
+	if err != nil {
+		s.reportError(err)
+	}
+}
+
+// sync flushes the log if it holds unflushed records
+func (s *Store) sync() error {
+	if !s.dirty {
+		return nil
+	}
+	err := s.log.Sync()
+	if err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// compact atomically replaces the snapshot with the current document, then empties the log
+func (s *Store) compact() error {
+	data, err := s.mem.Load().Mapping()
+	if err != nil {
+		return err
+	}
+	frame, err := encodeRecord(snapshotRecord{
+		Seq:  s.seq,
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, snapshotName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(frame)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), filepath.Join(s.dir, snapshotName))
+	if err != nil {
+		return err
+	}
+	err = syncDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	// Records up to seq are now part of the snapshot, and would be skipped by recovery if this fails
+	err = s.log.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = s.log.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	s.size = 0
+	s.records = 0
+	s.dirty = true
+	return s.sync()
+}
+
+// syncLoop periodically flushes the log until the Store is closed
+func (s *Store) syncLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			err := s.Sync()
+			if err != nil && err != ErrClosed {
+				s.reportError(err)
+			}
+		}
+	}
+}
+
+// reportError passes err to the configured error handler
+func (s *Store) reportError(err error) {
+	if s.opts.OnError != nil {
+		s.opts.OnError(err)
+	}
+}
+
+// syncDir flushes the directory entry changes within dir
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,304 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashibuto/keyval"
+)
+
+// expectDocument fails the test if the JSON representation of kv doesn't match expected
+func expectDocument(t *testing.T, kv *keyval.KeyVal, expected string) {
+	t.Helper()
+	data, err := kv.ToJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Expected %s, got %s", expected, string(data))
+	}
+}
+
+// reopen closes s and opens its directory again
+func reopen(t *testing.T, s *Store, opts Options) *Store {
+	t.Helper()
+	err := s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = Open(s.dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// logSize returns the size of the log file within dir
+func logSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(dir, logName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestPersistence(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectDocument(t, s.Load(), `{}`)
+
+	err = s.Set("localhost", "db", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Set(5432, "db", "port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Update(func(kv *keyval.KeyVal) error {
+		err := kv.CreateValue([]any{"a", "b"}, "tags")
+		if err != nil {
+			return err
+		}
+		return kv.CreateValue(false, "enabled")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Delete("db", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed update isn't logged
+	size := logSize(t, dir)
+	errFailed := errors.New("failed")
+	err = s.Update(func(kv *keyval.KeyVal) error {
+		kv.CreateValue(1, "partial")
+		return errFailed
+	})
+	if err != errFailed {
+		t.Fatalf("Expected the update's error, got %v", err)
+	}
+	if s.Delete("missing") == nil {
+		t.Fatalf("Expected an error deleting a missing key")
+	}
+	if logSize(t, dir) != size {
+		t.Fatalf("Expected failed updates not to be logged")
+	}
+
+	expected := `{"db":{"port":5432},"enabled":false,"tags":["a","b"]}`
+	expectDocument(t, s.Load(), expected)
+	s = reopen(t, s, Options{})
+	expectDocument(t, s.Load(), expected)
+	s.Close()
+
+	if s.Set(1, "a") != ErrClosed {
+		t.Fatalf("Expected ErrClosed")
+	}
+}
+
+func TestTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set(1, "a")
+	s.Set(2, "b")
+	s.Close()
+	size := logSize(t, dir)
+
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+	}{
+		{"partial header", func(data []byte) []byte {
+			return append(data, 0, 0, 0)
+		}},
+		{"partial payload", func(data []byte) []byte {
+			frame, _ := encodeRecord(logRecord{Seq: 3, Ops: []operation{{Op: opSet, Keys: []string{"c"}, Value: 3}}})
+			return append(data, frame[:len(frame)-2]...)
+		}},
+		{"bad checksum", func(data []byte) []byte {
+			frame, _ := encodeRecord(logRecord{Seq: 3, Ops: []operation{{Op: opSet, Keys: []string{"c"}, Value: 3}}})
+			frame[len(frame)-1] ^= 0xff
+			return append(data, frame...)
+		}},
+		{"huge length", func(data []byte) []byte {
+			frame, _ := encodeRecord(logRecord{Seq: 3, Ops: []operation{{Op: opSet, Keys: []string{"c"}, Value: 3}}})
+			binary.BigEndian.PutUint32(frame[0:4], maxRecordSize)
+			return append(data, frame...)
+		}},
+		{"sequence gap", func(data []byte) []byte {
+			frame, _ := encodeRecord(logRecord{Seq: 4, Ops: []operation{{Op: opSet, Keys: []string{"c"}, Value: 3}}})
+			return append(data, frame...)
+		}},
+	}
+
+	path := filepath.Join(dir, logName)
+	original, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		err := os.WriteFile(path, test.corrupt(append([]byte{}, original...)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := Open(dir, Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		expectDocument(t, s.Load(), `{"a":1,"b":2}`)
+		if logSize(t, dir) != size {
+			t.Fatalf("%s: Expected the log to be truncated to %d bytes, got %d", test.name, size, logSize(t, dir))
+		}
+
+		// New records follow the last intact one
+		err = s.Set(3, "c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s = reopen(t, s, Options{})
+		expectDocument(t, s.Load(), `{"a":1,"b":2,"c":3}`)
+		s.Close()
+	}
+}
+
+func TestCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set(1, "a")
+	s.Close()
+	path := filepath.Join(dir, logName)
+	original, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Damage followed by intact records isn't mistaken for a torn write, so the records following it aren't lost
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+	}{
+		{"bad checksum", func(data []byte) []byte {
+			data[len(data)-1] ^= 0xff
+			frame, _ := encodeRecord(logRecord{Seq: 2, Ops: []operation{{Op: opSet, Keys: []string{"b"}, Value: 2}}})
+			return append(data, frame...)
+		}},
+		{"sequence gap", func(data []byte) []byte {
+			for _, seq := range []uint64{3, 4} {
+				frame, _ := encodeRecord(logRecord{Seq: seq, Ops: []operation{{Op: opSet, Keys: []string{"b"}, Value: 2}}})
+				data = append(data, frame...)
+			}
+			return data
+		}},
+	}
+	for _, test := range tests {
+		corrupted := test.corrupt(append([]byte{}, original...))
+		err := os.WriteFile(path, corrupted, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Open(dir, Options{})
+		if err == nil {
+			t.Fatalf("%s: Expected an error opening a corrupt log", test.name)
+		}
+		if logSize(t, dir) != int64(len(corrupted)) {
+			t.Fatalf("%s: Expected the corrupt log to be left as it is", test.name)
+		}
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{CompactRecords: 3}
+	s, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 7; i++ {
+		err := s.Set(i, "count")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.records != 1 {
+		t.Fatalf("Expected 1 record after compaction, got %d", s.records)
+	}
+	s = reopen(t, s, opts)
+	expectDocument(t, s.Load(), `{"count":7}`)
+
+	// Simulate a crash after the snapshot was replaced but before the log was emptied
+	path := filepath.Join(dir, logName)
+	s.Set(8, "count")
+	s.Set(9, "count")
+	stale, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	err = os.WriteFile(path, stale, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectDocument(t, s.Load(), `{"count":9}`)
+	s.Set(10, "count")
+	s = reopen(t, s, opts)
+	expectDocument(t, s.Load(), `{"count":10}`)
+	s.Close()
+}
+
+func TestSyncInterval(t *testing.T) {
+	dir := t.TempDir()
+	errs := make(chan error, 1)
+	opts := Options{
+		Sync:         SyncInterval,
+		SyncInterval: 10 * time.Millisecond,
+		OnError: func(err error) {
+			errs <- err
+		},
+	}
+	s, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("value", "key")
+	time.Sleep(50 * time.Millisecond)
+	s.lock.Lock()
+	dirty := s.dirty
+	s.lock.Unlock()
+	if dirty {
+		t.Fatalf("Expected the log to have been flushed")
+	}
+	s = reopen(t, s, opts)
+	expectDocument(t, s.Load(), `{"key":"value"}`)
+	s.Close()
+
+	select {
+	case err := <-errs:
+		t.Fatalf("Unexpected error %v", err)
+	default:
+	}
+}
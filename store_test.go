@@ -0,0 +1,210 @@
+package keyval
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestStoreUpdate(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "localhost", "port": 5432}, "cache": {"size": 10}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	store := NewStore(kv)
+	first, version := store.LoadVersion()
+	if version != 1 {
+		t.Errorf("Expected version 1, got %d", version)
+		return
+	}
+
+	err = store.Update(func(kv *KeyVal) error {
+		err := kv.SetValue(5433, "db", "port")
+		if err != nil {
+			return err
+		}
+		return kv.CreateValue(true, "db", "tls", "enabled")
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	second := store.Load()
+	if store.Version() != 2 {
+		t.Errorf("Expected version 2, got %d", store.Version())
+		return
+	}
+
+	port, err := first.Number("db", "port")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if port != 5432 {
+		t.Errorf("Previous version was modified, port is %v", port)
+		return
+	}
+	_, err = first.Value("db", "tls")
+	if err == nil {
+		t.Errorf("Previous version was modified, tls is present")
+		return
+	}
+
+	port, err = second.Number("db", "port")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if port != 5433 {
+		t.Errorf("Expected 5433, got %v", port)
+		return
+	}
+
+	// The untouched subtree is shared between versions, the modified one isn't
+	firstCache, _ := first.Mapping("cache")
+	secondCache, _ := second.Mapping("cache")
+	if reflect.ValueOf(firstCache).Pointer() != reflect.ValueOf(secondCache).Pointer() {
+		t.Errorf("Expected unmodified mapping to be shared between versions")
+		return
+	}
+	firstDb, _ := first.Mapping("db")
+	secondDb, _ := second.Mapping("db")
+	if reflect.ValueOf(firstDb).Pointer() == reflect.ValueOf(secondDb).Pointer() {
+		t.Errorf("Expected modified mapping to be copied")
+		return
+	}
+}
+
+func TestStoreUpdateFailure(t *testing.T) {
+	store := NewStore(nil)
+	failure := errors.New("failure")
+	err := store.Update(func(kv *KeyVal) error {
+		err := kv.CreateValue(1, "a")
+		if err != nil {
+			return err
+		}
+		return failure
+	})
+	if err != failure {
+		t.Errorf("Expected failure, got %v", err)
+		return
+	}
+	if store.Version() != 1 {
+		t.Errorf("Expected version 1, got %d", store.Version())
+		return
+	}
+	_, err = store.Load().Value("a")
+	if err == nil {
+		t.Errorf("Failed update was published")
+		return
+	}
+}
+
+func TestStoreFrozen(t *testing.T) {
+	store := NewStore(nil)
+	err := store.Update(func(kv *KeyVal) error {
+		return kv.CreateValue(1, "a", "b")
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	kv := store.Load()
+	err = kv.SetValue(2, "a", "b")
+	if err != ErrFrozen {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+		return
+	}
+	sub, err := kv.GetKeyVal("a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = sub.SetValue(2, "b")
+	if err != ErrFrozen {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+		return
+	}
+
+	copy := kv.Copy()
+	err = copy.SetValue(2, "a", "b")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestStoreDraftFillDefaults(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "localhost"}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defaults, err := NewFromJson([]byte(`{"db": {"port": 5432}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	store := NewStore(kv)
+	first := store.Load()
+
+	err = store.Update(func(kv *KeyVal) error {
+		kv.FillDefaults(defaults)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = first.Value("db", "port")
+	if err == nil {
+		t.Errorf("Previous version was modified")
+		return
+	}
+	_, err = store.Load().Value("db", "port")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestStoreConcurrentReaders(t *testing.T) {
+	store := NewStore(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				store.Update(func(kv *KeyVal) error {
+					return kv.CreateValue(j, "workers", fmt.Sprint(i), "count")
+				})
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				kv, version := store.LoadVersion()
+				if version == 0 {
+					t.Errorf("Expected a non-zero version")
+				}
+				_, err := kv.ToJson()
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if store.Version() != 201 {
+		t.Errorf("Expected version 201, got %d", store.Version())
+		return
+	}
+}
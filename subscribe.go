@@ -0,0 +1,195 @@
+package keyval
+
+import (
+	"sync"
+)
+
+// subscriptions holds the change callbacks registered with a KeyVal
+type subscriptions struct {
+	lock    sync.Mutex
+	entries map[int]subscription
+	nextId  int
+}
+
+// subscription is a single change callback
+type subscription struct {
+	keys []string
+	fn   func(oldVal any, newVal any)
+}
+
+// observer holds the subscriptions of a KeyVal which a modification of the value at keys may affect, along with a copy
+// of the value at path, the outermost path any of them observes, taken before the modification
+type observer struct {
+	kv     *KeyVal
+	keys   []string
+	path   []string
+	subs   []subscription
+	before mergeValue
+}
+
+// OnChange registers fn to be called whenever a modification made through this KeyVal (SetValue, CreateValue,
+// DeleteValue, MergePatch, Replace or FillDefaults) changes the value at path, or anything beneath it.  path is split
+// into keys with SplitKey, and the empty string refers to the whole document.  fn receives copies of the value before
+// and after the modification, either of which is nil if the value was absent.  The copies are taken once per
+// modification and shared by every subscription it notifies, so they must not be modified.  A function which cancels
+// the subscription is returned.  Subscriptions observe modifications made through a KeyVal returned by GetKeyVal,
+// unless this KeyVal is persistent, in which case the two don't share data, however they don't carry over to copies of
+// the KeyVal.
+func (kv *KeyVal) OnChange(path string, fn func(oldVal any, newVal any)) func() {
+	if kv.subscriptions == nil {
+		kv.subscriptions = &subscriptions{
+			entries: map[int]subscription{},
+		}
+	}
+	subs := kv.subscriptions
+
+	keys := []string{}
+	if path != "" {
+		keys = SplitKey(path)
+	}
+
+	subs.lock.Lock()
+	defer subs.lock.Unlock()
+	id := subs.nextId
+	subs.nextId++
+	subs.entries[id] = subscription{
+		keys: keys,
+		fn:   fn,
+	}
+
+	return func() {
+		subs.lock.Lock()
+		defer subs.lock.Unlock()
+		delete(subs.entries, id)
+	}
+}
+
+// notify performs the modification fn, which affects the value at keys (nil for the whole document), and calls
+// every subscription whose value was changed by it.  Every modification of a KeyVal passes through notify or
+// notifyDocument.
+func (kv *KeyVal) notify(keys []string, modify func() error) error {
+	return kv.notifyWithin(keys, nil, modify)
+}
+
+// notifyDocument performs the modification fn, which affects the whole document, modifying in place only the
+// mappings at the positions of the mappings within (see record)
+func (kv *KeyVal) notifyDocument(within map[string]any, modify func() error) error {
+	return kv.notifyWithin(nil, within, modify)
+}
+
+// notifyWithin implements notify and notifyDocument
+func (kv *KeyVal) notifyWithin(keys []string, within map[string]any, modify func() error) error {
+	fn := func() error {
+		err := kv.record(keys, within, modify)
+		if err == nil {
+			kv.modified(keys)
+		}
+		return err
+	}
+	observers := kv.observers(keys)
+	if len(observers) == 0 {
+		return fn()
+	}
+
+	for _, o := range observers {
+		v, err := o.kv.lookup(o.path...)
+		o.before = mergeValue{deepCopy(v), err == nil}
+	}
+
+	err := fn()
+	if err != nil {
+		return err
+	}
+
+	for _, o := range observers {
+		// Only the value at keys was modified, so everything else is shared with the copy taken beforehand
+		after := mergeValue{}
+		if v, err := o.kv.lookup(o.path...); err == nil {
+			after = mergeValue{sharedCopy(o.before.value, v, o.keys[len(o.path):]), true}
+		}
+		for _, sub := range o.subs {
+			oldVal := valueAt(o.before, sub.keys[len(o.path):])
+			newVal := valueAt(after, sub.keys[len(o.path):])
+			if !sameMergeValue(oldVal, newVal) {
+				sub.fn(oldVal.value, newVal.value)
+			}
+		}
+	}
+	return nil
+}
+
+// observers returns the subscriptions which a modification of the value at keys (nil for the whole document) may
+// affect, those of the KeyVal along with those of the KeyVals it is a view of, grouped by KeyVal
+func (kv *KeyVal) observers(keys []string) []*observer {
+	observers := []*observer{}
+	for current := kv; ; current = current.view.parent {
+		if subs := current.subscriptions; subs != nil {
+			o := &observer{
+				kv:   current,
+				keys: keys,
+				path: keys,
+			}
+			subs.lock.Lock()
+			for id := 0; id < subs.nextId; id++ {
+				sub, ok := subs.entries[id]
+				if ok && overlaps(sub.keys, keys) {
+					o.subs = append(o.subs, sub)
+					if len(sub.keys) < len(o.path) {
+						o.path = sub.keys
+					}
+				}
+			}
+			subs.lock.Unlock()
+			if len(o.subs) > 0 {
+				observers = append(observers, o)
+			}
+		}
+		if current.view == nil {
+			return observers
+		}
+		keys = append(append([]string{}, current.view.keys...), keys...)
+	}
+}
+
+// sharedCopy returns a copy of after, the value following a modification of the value at keys beneath it, sharing
+// every value besides the one at keys with before, a copy of the value taken beforehand
+func sharedCopy(before any, after any, keys []string) any {
+	afterMap, ok := after.(map[string]any)
+	if len(keys) == 0 || !ok {
+		return deepCopy(after)
+	}
+	beforeMap, _ := before.(map[string]any)
+	result := make(map[string]any, len(afterMap))
+	for key, val := range afterMap {
+		if key == keys[0] {
+			result[key] = sharedCopy(beforeMap[key], val, keys[1:])
+		} else if prev, ok := beforeMap[key]; ok {
+			result[key] = prev
+		} else {
+			result[key] = deepCopy(val)
+		}
+	}
+	return result
+}
+
+// valueAt returns the value at keys within v
+func valueAt(v mergeValue, keys []string) mergeValue {
+	for _, key := range keys {
+		obj, ok := v.value.(map[string]any)
+		if !v.present || !ok {
+			return mergeValue{}
+		}
+		v = lookupMergeValue(obj, key)
+	}
+	return v
+}
+
+// overlaps returns true if one of the paths lies at or beneath the other
+func overlaps(a []string, b []string) bool {
+	for idx := 0; idx < len(a) && idx < len(b); idx++ {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,223 @@
+package keyval
+
+import (
+	"reflect"
+	"testing"
+)
+
+// changeRecord captures a single change callback
+type changeRecord struct {
+	path   string
+	oldVal any
+	newVal any
+}
+
+// recordChanges subscribes to each of paths, returning a pointer to the list of callbacks received
+func recordChanges(kv *KeyVal, paths ...string) *[]changeRecord {
+	records := &[]changeRecord{}
+	for _, path := range paths {
+		path := path
+		kv.OnChange(path, func(oldVal any, newVal any) {
+			*records = append(*records, changeRecord{path, oldVal, newVal})
+		})
+	}
+	return records
+}
+
+func TestOnChange(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "h1", "port": 5432}, "cache": {"size": 10}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	records := recordChanges(kv, "db", "db.port", "cache")
+
+	err = kv.SetValue("h2", "db", "host")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(*records) != 1 || (*records)[0].path != "db" {
+		t.Errorf("Expected only db to change, got %v", *records)
+		return
+	}
+	oldDb := (*records)[0].oldVal.(map[string]any)
+	newDb := (*records)[0].newVal.(map[string]any)
+	if oldDb["host"] != "h1" || newDb["host"] != "h2" {
+		t.Errorf("Unexpected old/new values %v/%v", oldDb, newDb)
+		return
+	}
+
+	// Setting an identical value isn't a change
+	*records = nil
+	err = kv.SetValue(5432, "db", "port")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(*records) != 0 {
+		t.Errorf("Expected no changes, got %v", *records)
+		return
+	}
+
+	// Replacing an ancestor affects its descendants
+	*records = nil
+	err = kv.SetValue(map[string]any{"host": "h3"}, "db")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(*records) != 2 || (*records)[1].path != "db.port" || (*records)[1].newVal != nil {
+		t.Errorf("Expected db and db.port to change, got %v", *records)
+		return
+	}
+
+	*records = nil
+	err = kv.DeleteValue("cache", "size")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(*records) != 1 || (*records)[0].path != "cache" {
+		t.Errorf("Expected cache to change, got %v", *records)
+		return
+	}
+
+	*records = nil
+	err = kv.CreateValue(1, "cache", "ttl")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(*records) != 1 || (*records)[0].path != "cache" {
+		t.Errorf("Expected cache to change, got %v", *records)
+		return
+	}
+}
+
+func TestOnChangeDocumentOperations(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "h1", "port": 5432}, "cache": {"size": 10}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	records := recordChanges(kv, "db", "cache")
+
+	patch, err := NewFromJson([]byte(`{"db": {"port": null, "tls": true}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = kv.MergePatch(patch)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(*records) != 1 || (*records)[0].path != "db" {
+		t.Errorf("Expected db to change, got %v", *records)
+		return
+	}
+	expectJson(t, kv, `{"cache":{"size":10},"db":{"host":"h1","tls":true}}`)
+
+	*records = nil
+	layer, err := NewFromJson([]byte(`{"cache": {"size": 20}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = kv.Replace(kv.Stack(layer))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(*records) != 1 || (*records)[0].path != "cache" {
+		t.Errorf("Expected cache to change, got %v", *records)
+		return
+	}
+
+	*records = nil
+	defaults, err := NewFromJson([]byte(`{"cache": {"ttl": 60}, "db": {"host": "ignored"}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	kv.FillDefaults(defaults)
+	if len(*records) != 1 || (*records)[0].path != "cache" {
+		t.Errorf("Expected cache to change, got %v", *records)
+		return
+	}
+}
+
+func TestOnChangeUnsubscribe(t *testing.T) {
+	kv := New()
+	calls := 0
+	unsubscribe := kv.OnChange("", func(oldVal any, newVal any) {
+		calls++
+	})
+	kv.CreateValue(1, "a")
+	unsubscribe()
+	kv.CreateValue(2, "a")
+	if calls != 1 {
+		t.Errorf("Expected 1 call, got %d", calls)
+		return
+	}
+}
+
+func TestOnChangeShared(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "h1", "tls": {"enabled": false}}, "cache": {"size": 10}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	records := recordChanges(kv, "", "db", "db.tls")
+
+	// Every subscription receives the same copies, unchanged values being shared between the old and new ones
+	err = kv.SetValue(true, "db", "tls", "enabled")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(*records) != 3 {
+		t.Errorf("Expected 3 changes, got %v", *records)
+		return
+	}
+	oldRoot := (*records)[0].oldVal.(map[string]any)
+	newRoot := (*records)[0].newVal.(map[string]any)
+	oldDb := (*records)[1].oldVal.(map[string]any)
+	newDb := (*records)[1].newVal.(map[string]any)
+	if !sameMapping(oldRoot["db"], oldDb) || !sameMapping(newRoot["db"], newDb) || !sameMapping(oldRoot["cache"], newRoot["cache"]) {
+		t.Errorf("Expected the copies to be shared")
+		return
+	}
+	cache, _ := kv.Mapping("cache")
+	if sameMapping(newRoot["cache"], cache) {
+		t.Errorf("Expected the values to be copies")
+		return
+	}
+	expectJson(t, NewFromMap(oldRoot), `{"cache":{"size":10},"db":{"host":"h1","tls":{"enabled":false}}}`)
+	expectJson(t, NewFromMap(newRoot), `{"cache":{"size":10},"db":{"host":"h1","tls":{"enabled":true}}}`)
+
+	// Modifications made through a sub-KeyVal notify the subscriptions of the KeyVal it was taken from
+	*records = nil
+	sub, err := kv.GetKeyVal("db")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = sub.SetValue("h2", "host")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(*records) != 2 || (*records)[1].path != "db" || (*records)[1].newVal.(map[string]any)["host"] != "h2" {
+		t.Errorf("Expected the root and db to change, got %v", *records)
+		return
+	}
+}
+
+// sameMapping returns true if a and b are the same mapping
+func sameMapping(a any, b any) bool {
+	mapA, okA := a.(map[string]any)
+	mapB, okB := b.(map[string]any)
+	return okA && okB && reflect.ValueOf(mapA).Pointer() == reflect.ValueOf(mapB).Pointer()
+}
@@ -0,0 +1,326 @@
+package keyval
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SyncKeyVal wraps a KeyVal, guarding every access with a read/write mutex so that it may be shared between
+// goroutines.  Values returned by the getters are copies, so they may be retained and modified without holding the
+// lock.
+type SyncKeyVal struct {
+	lock sync.RWMutex
+	kv   *KeyVal
+}
+
+// NewSync returns a new SyncKeyVal which takes ownership of kv.  kv must not be accessed directly afterward.  If kv
+// is nil, an empty KeyVal is used.
+func NewSync(kv *KeyVal) *SyncKeyVal {
+	if kv == nil {
+		kv = New()
+	}
+	return &SyncKeyVal{
+		kv: kv,
+	}
+}
+
+// View calls fn with the underlying KeyVal while holding the read lock.  fn must not modify the KeyVal or retain any
+// reference to it, or any data obtained from it, after returning.
+func (s *SyncKeyVal) View(fn func(kv *KeyVal) error) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return fn(s.kv)
+}
+
+// Update calls fn with the underlying KeyVal while holding the write lock, allowing a batch of modifications to be
+// applied without any reader observing an intermediate state.  fn must not retain any reference to the KeyVal after
+// returning.
+func (s *SyncKeyVal) Update(fn func(kv *KeyVal) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return fn(s.kv)
+}
+
+// Transaction calls fn with a draft of the document while holding the write lock, applying every modification fn
+// made to the draft if it returns without error, or none of them if it returns an error.  fn must not retain any
+// reference to the draft after returning.
+func (s *SyncKeyVal) Transaction(fn func(tx *KeyVal) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kv.Transaction(fn)
+}
+
+// Snapshot returns a deep copy of the underlying KeyVal
+func (s *SyncKeyVal) Snapshot() *KeyVal {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.Copy()
+}
+
+// GetKeyVal returns a copy of the data at the nested key position as a new KeyVal
+func (s *SyncKeyVal) GetKeyVal(keys ...string) (*KeyVal, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	kv, err := s.kv.GetKeyVal(keys...)
+	if err != nil {
+		return nil, err
+	}
+	return kv.Copy(), nil
+}
+
+// SetValue sets a nested value within the object.  If a parent key cannot be located, an error is returned.
+func (s *SyncKeyVal) SetValue(value any, keys ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kv.SetValue(deepCopy(value), keys...)
+}
+
+// CreateValue sets a nested value within the object.  If a parent key cannot be located, it is created.
+func (s *SyncKeyVal) CreateValue(value any, keys ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kv.CreateValue(deepCopy(value), keys...)
+}
+
+// CompareAndSet sets a nested value within the object, provided that its version is still expectedVersion
+func (s *SyncKeyVal) CompareAndSet(expectedVersion uint64, value any, keys ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kv.CompareAndSet(expectedVersion, deepCopy(value), keys...)
+}
+
+// DeleteValue removes a nested value from the object
+func (s *SyncKeyVal) DeleteValue(keys ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kv.DeleteValue(keys...)
+}
+
+// MergePatch applies an RFC 7386 JSON Merge Patch to the object
+func (s *SyncKeyVal) MergePatch(patch *KeyVal) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kv.MergePatch(patch)
+}
+
+// ApplyPatch applies an RFC 6902 JSON Patch to the object, applying either every operation or none of them
+func (s *SyncKeyVal) ApplyPatch(ops []PatchOperation) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kv.ApplyPatch(ops)
+}
+
+// Replace replaces the entire contents of the object with a copy of other
+func (s *SyncKeyVal) Replace(other *KeyVal) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kv.Replace(other)
+}
+
+// SetKeyProvider configures the provider of the keys used to encrypt and decrypt secrets
+func (s *SyncKeyVal) SetKeyProvider(provider KeyProvider) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.kv.SetKeyProvider(provider)
+}
+
+// Encrypt replaces the values at paths with secrets encrypted using the provider's current key
+func (s *SyncKeyVal) Encrypt(paths ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kv.Encrypt(paths...)
+}
+
+// RotateKey re-encrypts every secret within the document with the provider's current key
+func (s *SyncKeyVal) RotateKey() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kv.RotateKey()
+}
+
+// OnChange registers fn to be called whenever a modification changes the value at path, returning a function which
+// cancels the subscription.  fn is called while the write lock is held, so it must not access the SyncKeyVal.
+func (s *SyncKeyVal) OnChange(path string, fn func(oldVal any, newVal any)) func() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kv.OnChange(path, fn)
+}
+
+// Version returns the version of the value at keys, or of the whole document if no keys are given
+func (s *SyncKeyVal) Version(keys ...string) uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.Version(keys...)
+}
+
+// Value returns a copy of a value or an error if the value cannot be located
+func (s *SyncKeyVal) Value(keys ...string) (any, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	v, err := s.kv.Value(keys...)
+	if err != nil {
+		return nil, err
+	}
+	return deepCopy(v), nil
+}
+
+// String returns a string or an error if the data can't be found, or properly cast
+func (s *SyncKeyVal) String(keys ...string) (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.String(keys...)
+}
+
+// Number returns a float or an error if the data can't be found, or properly cast
+func (s *SyncKeyVal) Number(keys ...string) (float64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.Number(keys...)
+}
+
+// Boolean returns a boolean or an error if the data can't be found, or properly cast
+func (s *SyncKeyVal) Boolean(keys ...string) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.Boolean(keys...)
+}
+
+// Array returns a copy of an array or an error if the data can't be found, or properly cast
+func (s *SyncKeyVal) Array(keys ...string) ([]any, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	v, err := s.kv.Array(keys...)
+	if err != nil {
+		return nil, err
+	}
+	return deepCopy(v).([]any), nil
+}
+
+// Mapping returns a copy of a mapping or an error if the data can't be found, or properly cast
+func (s *SyncKeyVal) Mapping(keys ...string) (map[string]any, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	v, err := s.kv.Mapping(keys...)
+	if err != nil {
+		return nil, err
+	}
+	return deepCopy(v).(map[string]any), nil
+}
+
+// Copy returns a deep copy of SyncKeyVal
+func (s *SyncKeyVal) Copy() *SyncKeyVal {
+	return NewSync(s.Snapshot())
+}
+
+// Stack creates a new SyncKeyVal object with the current instance being the base, and layer being stacked atop
+func (s *SyncKeyVal) Stack(layer *KeyVal) *SyncKeyVal {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return NewSync(s.kv.Stack(layer))
+}
+
+// StackSync creates a new SyncKeyVal object with the current instance being the base, and another SyncKeyVal being
+// stacked atop
+func (s *SyncKeyVal) StackSync(layer *SyncKeyVal) *SyncKeyVal {
+	// Snapshot the layer first so that the two locks are never held together
+	top := layer.Snapshot()
+	return s.Stack(top)
+}
+
+// Redacted returns a copy of the underlying KeyVal in which every value matched by one of rules is masked
+func (s *SyncKeyVal) Redacted(rules ...string) *KeyVal {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.Redacted(rules...)
+}
+
+// Format implements fmt.Formatter, printing the underlying KeyVal with the values matched by DefaultRedactionRules
+// masked
+func (s *SyncKeyVal) Format(f fmt.State, verb rune) {
+	s.Redacted().Format(f, verb)
+}
+
+// ToJson marshals the entire data structure to a JSON byte array
+func (s *SyncKeyVal) ToJson() ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.ToJson()
+}
+
+// ToYaml marshals the entire data structure to a YAML byte array
+func (s *SyncKeyVal) ToYaml() ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.ToYaml()
+}
+
+// ToJsonWithOptions marshals the entire data structure to a JSON byte array, as governed by opts
+func (s *SyncKeyVal) ToJsonWithOptions(opts MarshalOptions) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.ToJsonWithOptions(opts)
+}
+
+// ToYamlWithOptions marshals the entire data structure to a YAML byte array, as governed by opts
+func (s *SyncKeyVal) ToYamlWithOptions(opts MarshalOptions) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.ToYamlWithOptions(opts)
+}
+
+// Decode unmarshals the entire data structure into target, which is populated according to its json struct tags
+func (s *SyncKeyVal) Decode(target any) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.Decode(target)
+}
+
+// Resolve returns a new KeyVal with all interpolation expressions within string values expanded
+func (s *SyncKeyVal) Resolve() (*KeyVal, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.Resolve()
+}
+
+// ResolveValue returns a value with all interpolation expressions expanded, or an error if the value cannot be
+// located or resolved
+func (s *SyncKeyVal) ResolveValue(keys ...string) (any, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	v, err := s.kv.ResolveValue(keys...)
+	if err != nil {
+		return nil, err
+	}
+	return deepCopy(v), nil
+}
+
+// ResolveString returns a string with all interpolation expressions expanded, or an error if the data can't be
+// found, resolved, or properly cast
+func (s *SyncKeyVal) ResolveString(keys ...string) (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.ResolveString(keys...)
+}
+
+// Validate checks the document against a JSON schema, returning every violation found
+func (s *SyncKeyVal) Validate(schema *KeyVal) ([]Violation, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.kv.Validate(schema)
+}
+
+// FillDefaults inserts every key from defaults which is absent from the document, returning JSON pointers to the
+// keys which were defaulted
+func (s *SyncKeyVal) FillDefaults(defaults *KeyVal) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kv.FillDefaults(defaults)
+}
+
+// FillSchemaDefaults inserts the "default" of every schema property which is absent from the document, returning
+// JSON pointers to the keys which were defaulted
+func (s *SyncKeyVal) FillSchemaDefaults(schema *KeyVal) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kv.FillSchemaDefaults(schema)
+}
@@ -0,0 +1,209 @@
+package keyval
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSyncConcurrentAccess(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"name": "app", "enabled": true, "db": {"host": "localhost", "port": 5432}, "tags": ["a"], "url": "${db.host}"}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testSyncConcurrentAccess(t, NewSync(kv.Copy()))
+	testSyncConcurrentAccess(t, NewSync(kv.Persistent()))
+}
+
+// testSyncConcurrentAccess calls every method of skv from many goroutines at once
+func testSyncConcurrentAccess(t *testing.T, skv *SyncKeyVal) {
+	layer, err := NewFromJson([]byte(`{"db": {"port": 5433}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	schema, err := NewFromJson([]byte(`{"properties": {"counter": {"default": 0}}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ops := []func(i int) error{
+		func(i int) error { return skv.SetValue(i, "db", "port") },
+		func(i int) error { return skv.CreateValue(i, "workers", fmt.Sprint(i%4), "id") },
+		func(i int) error {
+			_, err := skv.Value("db")
+			return err
+		},
+		func(i int) error {
+			_, err := skv.String("name")
+			return err
+		},
+		func(i int) error {
+			_, err := skv.Number("db", "port")
+			return err
+		},
+		func(i int) error {
+			_, err := skv.Boolean("enabled")
+			return err
+		},
+		func(i int) error {
+			arr, err := skv.Array("tags")
+			if err == nil {
+				arr[0] = i
+			}
+			return err
+		},
+		func(i int) error {
+			m, err := skv.Mapping("db")
+			if err == nil {
+				m["host"] = i
+			}
+			return err
+		},
+		func(i int) error {
+			sub, err := skv.GetKeyVal("db")
+			if err == nil {
+				err = sub.SetValue(i, "port")
+			}
+			return err
+		},
+		func(i int) error { return skv.Copy().SetValue(i, "name") },
+		func(i int) error { return skv.Stack(layer).SetValue(i, "name") },
+		func(i int) error { return skv.StackSync(skv.Copy()).SetValue(i, "name") },
+		func(i int) error { return skv.Snapshot().SetValue(i, "name") },
+		func(i int) error {
+			_, err := skv.ToJson()
+			return err
+		},
+		func(i int) error {
+			_, err := skv.ToYaml()
+			return err
+		},
+		func(i int) error {
+			var target map[string]any
+			return skv.Decode(&target)
+		},
+		func(i int) error {
+			_, err := skv.Resolve()
+			return err
+		},
+		func(i int) error {
+			_, err := skv.ResolveValue("url")
+			return err
+		},
+		func(i int) error {
+			_, err := skv.ResolveString("url")
+			return err
+		},
+		func(i int) error {
+			_, err := skv.Validate(schema)
+			return err
+		},
+		func(i int) error {
+			skv.FillDefaults(layer)
+			return nil
+		},
+		func(i int) error {
+			_, err := skv.FillSchemaDefaults(schema)
+			return err
+		},
+		func(i int) error {
+			return skv.Update(func(kv *KeyVal) error {
+				err := kv.SetValue(i, "db", "port")
+				if err != nil {
+					return err
+				}
+				return kv.SetValue(fmt.Sprint(i), "name")
+			})
+		},
+		func(i int) error {
+			return skv.View(func(kv *KeyVal) error {
+				_, err := kv.Number("db", "port")
+				return err
+			})
+		},
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(ops)*20)
+	for i := 0; i < 20; i++ {
+		for _, op := range ops {
+			wg.Add(1)
+			go func(op func(int) error, i int) {
+				defer wg.Done()
+				err := op(i)
+				if err != nil {
+					errs <- err
+				}
+			}(op, i)
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+		return
+	}
+}
+
+func TestSyncUpdateBatch(t *testing.T) {
+	skv := NewSync(nil)
+	err := skv.CreateValue("localhost", "db", "host")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			skv.Update(func(kv *KeyVal) error {
+				kv.SetValue(i, "db", "port")
+				return kv.SetValue(i, "db", "replica")
+			})
+		}(i)
+		go func() {
+			defer wg.Done()
+			skv.View(func(kv *KeyVal) error {
+				port, _ := kv.Number("db", "port")
+				replica, _ := kv.Number("db", "replica")
+				if port != replica {
+					t.Errorf("Observed a partially applied batch: %v != %v", port, replica)
+				}
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSyncCopyIsolation(t *testing.T) {
+	skv := NewSync(nil)
+	err := skv.CreateValue(1, "a", "b")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	m, err := skv.Mapping("a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	m["b"] = 2
+
+	v, err := skv.Number("a", "b")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if v != 1 {
+		t.Errorf("Expected 1, got %v", v)
+		return
+	}
+}
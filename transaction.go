@@ -0,0 +1,101 @@
+package keyval
+
+import (
+	"errors"
+)
+
+var (
+	// ErrTxDone is returned when committing a transaction which was already committed or rolled back
+	ErrTxDone = errors.New("Transaction has already been committed or rolled back")
+	// ErrTxConflict is returned when committing a transaction whose KeyVal was modified after the transaction began
+	ErrTxConflict = errors.New("KeyVal was modified during the transaction")
+)
+
+// Tx is a transaction against a KeyVal, begun with Begin.  Modifications made through the Tx's embedded KeyVal are
+// invisible to the original KeyVal until Commit applies all of them at once.  Rollback discards them.
+type Tx struct {
+	*KeyVal
+	parent   *KeyVal
+	revision uint64
+	done     bool
+}
+
+// Begin starts a transaction, returning a Tx whose embedded KeyVal is a draft of the document.  The draft is
+// persistent: it copies only the mappings along the paths it modifies, and mappings and arrays obtained from its
+// getters must not be modified directly.  The draft of a persistent KeyVal shares structure with it, so beginning the
+// transaction takes constant time, while that of any other KeyVal starts from a deep copy, and Commit copies the
+// draft back, isolating the draft from modifications made to the KeyVal in place.
+func (kv *KeyVal) Begin() *Tx {
+	var draft *KeyVal
+	if kv.owned != nil {
+		draft = kv.share(kv.root, false)
+	} else {
+		draft = kv.inherit(newPersistent(deepCopy(kv.root).(map[string]any), fullOwnership()))
+	}
+	return &Tx{
+		KeyVal:   draft,
+		parent:   kv,
+		revision: kv.revision,
+	}
+}
+
+// Commit replaces the content of the original KeyVal with that of the draft, notifying any subscribers.  If the
+// original KeyVal was modified after the transaction began, nothing is applied and ErrTxConflict is returned.  The
+// transaction is finished either way, and the draft becomes frozen.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	defer tx.KeyVal.freeze()
+
+	parent := tx.parent
+	if parent.frozen {
+		return ErrFrozen
+	}
+	if parent.revision != tx.revision {
+		return ErrTxConflict
+	}
+	if tx.KeyVal.revision == 0 {
+		// Nothing was modified
+		return nil
+	}
+
+	draft := tx.KeyVal
+	persistent := parent.owned != nil
+	return parent.notifyDocument(map[string]any{}, func() error {
+		if !persistent {
+			// The original KeyVal modifies its tree in place, while the frozen draft, and anything sharing its tree,
+			// must not change, so it takes a copy of its own
+			parent.root = deepCopy(draft.root).(map[string]any)
+			return nil
+		}
+		parent.root = draft.root
+		// Everything the draft owns is reachable only from the draft, which is about to be frozen
+		parent.setOwnership(draft.ownership())
+		return nil
+	})
+}
+
+// Rollback discards the modifications made within the transaction.  Rolling back a finished transaction has no
+// effect, so it is safe to defer a Rollback immediately after Begin.
+func (tx *Tx) Rollback() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+	tx.KeyVal.freeze()
+}
+
+// Transaction calls fn with a draft of the document, applying every modification fn made to the draft if it returns
+// without error, or none of them if it returns an error.  See Begin and Commit.
+func (kv *KeyVal) Transaction(fn func(tx *KeyVal) error) error {
+	tx := kv.Begin()
+	defer tx.Rollback()
+
+	err := fn(tx.KeyVal)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,218 @@
+package keyval
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "h1", "port": 5432}, "name": "app"}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testTransaction(t, kv.Copy())
+	testTransaction(t, kv.Persistent())
+}
+
+// testTransaction checks that a transaction applies all of its modifications or none of them
+func testTransaction(t *testing.T, kv *KeyVal) {
+	original := `{"db":{"host":"h1","port":5432},"name":"app"}`
+	errFailed := errors.New("failed")
+	err := kv.Transaction(func(tx *KeyVal) error {
+		err := tx.SetValue("h2", "db", "host")
+		if err != nil {
+			return err
+		}
+		err = tx.CreateValue(true, "cache", "enabled")
+		if err != nil {
+			return err
+		}
+		err = tx.DeleteValue("name")
+		if err != nil {
+			return err
+		}
+		expectJson(t, kv, original)
+		return errFailed
+	})
+	if err != errFailed {
+		t.Errorf("Expected the transaction's error, got %v", err)
+		return
+	}
+	expectJson(t, kv, original)
+
+	// A failing SetValue part way through leaves the document untouched
+	err = kv.Transaction(func(tx *KeyVal) error {
+		err := tx.SetValue("h2", "db", "host")
+		if err != nil {
+			return err
+		}
+		return tx.SetValue(1, "missing", "key")
+	})
+	if err == nil {
+		t.Errorf("Expected an error")
+		return
+	}
+	expectJson(t, kv, original)
+
+	err = kv.Transaction(func(tx *KeyVal) error {
+		err := tx.SetValue("h2", "db", "host")
+		if err != nil {
+			return err
+		}
+		err = tx.CreateValue(true, "cache", "enabled")
+		if err != nil {
+			return err
+		}
+		return tx.DeleteValue("name")
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, kv, `{"cache":{"enabled":true},"db":{"host":"h2","port":5432}}`)
+
+	// The committed document remains modifiable
+	err = kv.SetValue(1, "db", "port")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, kv, `{"cache":{"enabled":true},"db":{"host":"h2","port":1}}`)
+}
+
+func TestBeginCommitRollback(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"a": {"b": 1}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	changes := 0
+	kv.OnChange("a", func(oldVal any, newVal any) {
+		changes++
+	})
+
+	tx := kv.Begin()
+	tx.SetValue(2, "a", "b")
+	tx.CreateValue(3, "a", "c")
+	tx.Rollback()
+	expectJson(t, kv, `{"a":{"b":1}}`)
+	if tx.SetValue(4, "a", "b") != ErrFrozen {
+		t.Errorf("Expected a rolled back transaction to be frozen")
+		return
+	}
+	if tx.Commit() != ErrTxDone {
+		t.Errorf("Expected ErrTxDone")
+		return
+	}
+
+	tx = kv.Begin()
+	tx.SetValue(2, "a", "b")
+	tx.CreateValue(3, "a", "c")
+	err = tx.Commit()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectJson(t, kv, `{"a":{"b":2,"c":3}}`)
+	if changes != 1 {
+		t.Errorf("Expected 1 change notification, got %d", changes)
+		return
+	}
+	if tx.Commit() != ErrTxDone {
+		t.Errorf("Expected ErrTxDone")
+		return
+	}
+}
+
+func TestTransactionConflict(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"a": 1}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tx := kv.Begin()
+	tx.SetValue(2, "a")
+	kv.SetValue(3, "a")
+	err = tx.Commit()
+	if err != ErrTxConflict {
+		t.Errorf("Expected ErrTxConflict, got %v", err)
+		return
+	}
+	expectJson(t, kv, `{"a":3}`)
+
+	frozen := NewStore(kv).Load()
+	err = frozen.Transaction(func(tx *KeyVal) error {
+		return tx.SetValue(4, "a")
+	})
+	if err != ErrFrozen {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+	}
+}
+
+func TestTransactionIsolation(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "h1", "port": 5432}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A copy taken within the transaction is unaffected by modifications made after the commit
+	tx := kv.Begin()
+	tx.SetValue("h2", "db", "host")
+	copy := tx.Copy()
+	err = tx.Commit()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	kv.SetValue(1, "db", "port")
+	kv.SetValue("h3", "db", "host")
+	expectJson(t, copy, `{"db":{"host":"h2","port":5432}}`)
+	expectJson(t, kv, `{"db":{"host":"h3","port":1}}`)
+
+	// The committed draft itself is unaffected by modifications made after the commit
+	tx = kv.Begin()
+	tx.SetValue("h5", "db", "host")
+	err = tx.Commit()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	kv.SetValue("h3", "db", "host")
+	expectJson(t, tx.KeyVal, `{"db":{"host":"h5","port":1}}`)
+
+	// Modifications made through a sub-KeyVal, taken before or during the transaction, are invisible to the draft
+	// and conflict with the transaction
+	before, err := kv.GetKeyVal("db")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, taken := range []string{"before", "during"} {
+		tx = kv.Begin()
+		tx.SetValue("h4", "db", "host")
+		sub := before
+		if taken == "during" {
+			sub, err = kv.GetKeyVal("db")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		sub.SetValue(2, "port")
+		port, _ := tx.Number("db", "port")
+		if port != 1 {
+			t.Errorf("%s: Expected the draft to be isolated, got port %v", taken, port)
+			return
+		}
+		err = tx.Commit()
+		if err != ErrTxConflict {
+			t.Errorf("%s: Expected ErrTxConflict, got %v", taken, err)
+			return
+		}
+		expectJson(t, kv, `{"db":{"host":"h3","port":2}}`)
+		kv.SetValue(1, "db", "port")
+	}
+}
@@ -0,0 +1,107 @@
+package keyval
+
+import (
+	"fmt"
+)
+
+// VersionConflictError is returned by CompareAndSet when the value was modified since the expected version
+type VersionConflictError struct {
+	// Keys locates the value being set
+	Keys []string
+	// Expected is the version the caller based its modification on
+	Expected uint64
+	// Actual is the current version of the value
+	Actual uint64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("Value at \"%s\" is at version %d, expected version %d", formatPointer(e.Keys...), e.Actual,
+		e.Expected)
+}
+
+// versionNode records the revisions at which the value at a path, and the values beneath it, last changed
+type versionNode struct {
+	// self is the revision at which the value at the path was last replaced as a whole
+	self uint64
+	// subtree is the revision at which the value at or beneath the path last changed
+	subtree uint64
+	// children holds the paths beneath this one modified since self
+	children map[string]*versionNode
+}
+
+// Version returns the version of the value at keys, or of the whole document if no keys are given.  The document's
+// version counts the modifications made to it, starting from zero, and the version of a value is the document version
+// at which it, or anything beneath it, was last modified.  A value which doesn't exist has a version too, so that its
+// creation can be made conditional.  Modifications of the whole document (MergePatch, ApplyPatch, Replace,
+// FillDefaults, Undo, Redo, or a committed transaction) count as modifying every value.  Versions don't carry over to
+// copies of the KeyVal.
+func (kv *KeyVal) Version(keys ...string) uint64 {
+	var version uint64
+	node := kv.versions
+	for idx := 0; node != nil; idx++ {
+		if idx == len(keys) {
+			if node.subtree > version {
+				version = node.subtree
+			}
+			break
+		}
+		if node.self > version {
+			version = node.self
+		}
+		node = node.children[keys[idx]]
+	}
+	return version
+}
+
+// CompareAndSet sets the value at keys as SetValue does, provided that its version is still expectedVersion, as
+// previously returned by Version.  If the value was modified in the meantime, nothing is set and a
+// *VersionConflictError is returned, so that concurrent writers can detect an update which would otherwise be lost,
+// then re-read the value and try again.
+func (kv *KeyVal) CompareAndSet(expectedVersion uint64, value any, keys ...string) error {
+	if kv.frozen {
+		return ErrFrozen
+	}
+	actual := kv.Version(keys...)
+	if actual != expectedVersion {
+		return &VersionConflictError{
+			Keys:     keys,
+			Expected: expectedVersion,
+			Actual:   actual,
+		}
+	}
+	return kv.SetValue(value, keys...)
+}
+
+// modified advances the revision, recording that the value at keys (nil for the whole document) was modified.  A
+// view's parent records the modification too, since the view modified the parent's data.
+func (kv *KeyVal) modified(keys []string) {
+	kv.revision++
+	kv.touch(keys)
+	if kv.view != nil {
+		kv.view.parent.modified(append(append([]string{}, kv.view.keys...), keys...))
+	}
+}
+
+// touch records that the value at keys (nil for the whole document) was modified at the current revision
+func (kv *KeyVal) touch(keys []string) {
+	if kv.versions == nil {
+		kv.versions = &versionNode{}
+	}
+	node := kv.versions
+	node.subtree = kv.revision
+	for _, key := range keys {
+		child, ok := node.children[key]
+		if !ok {
+			if node.children == nil {
+				node.children = map[string]*versionNode{}
+			}
+			child = &versionNode{}
+			node.children[key] = child
+		}
+		child.subtree = kv.revision
+		node = child
+	}
+	// Everything beneath was replaced along with the value, so the children's versions are superseded
+	node.self = kv.revision
+	node.children = nil
+}
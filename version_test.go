@@ -0,0 +1,120 @@
+package keyval
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	kv, err := NewFromJson([]byte(`{"db": {"host": "localhost", "port": 5432}, "name": "app"}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if kv.Version() != 0 || kv.Version("db", "port") != 0 {
+		t.Errorf("Expected an unmodified document to be at version 0")
+		return
+	}
+
+	kv.SetValue(5433, "db", "port")
+	kv.SetValue("web", "name")
+	kv.CreateValue(true, "db", "tls", "enabled")
+	kv.SetValue(1, "missing", "key")
+
+	tests := []struct {
+		keys    []string
+		version uint64
+	}{
+		{[]string{}, 3},
+		{[]string{"db"}, 3},
+		{[]string{"db", "port"}, 1},
+		{[]string{"db", "host"}, 0},
+		{[]string{"db", "tls", "enabled"}, 3},
+		{[]string{"name"}, 2},
+		{[]string{"missing"}, 0},
+	}
+	for _, test := range tests {
+		if version := kv.Version(test.keys...); version != test.version {
+			t.Errorf("%v: Expected version %d, got %d", test.keys, test.version, version)
+			return
+		}
+	}
+
+	// Replacing a mapping modifies everything beneath it
+	kv.SetValue(map[string]any{"host": "db.internal"}, "db")
+	if kv.Version("db", "host") != 4 || kv.Version("db", "port") != 4 {
+		t.Errorf("Expected the values beneath a replaced mapping to be at version 4")
+		return
+	}
+	kv.DeleteValue("db", "host")
+	if kv.Version("db", "host") != 5 || kv.Version("name") != 2 {
+		t.Errorf("Expected only the deleted value to be at version 5")
+		return
+	}
+	kv.MergePatch(NewFromMap(map[string]any{"name": "api"}))
+	if kv.Version("db") != 6 {
+		t.Errorf("Expected a whole document modification to modify every value")
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+	kv := NewFromMap(map[string]any{"counter": 0, "other": 0})
+	version := kv.Version("counter")
+	err := kv.CompareAndSet(version, 1, "counter")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Modifying an unrelated value doesn't conflict
+	kv.SetValue(1, "other")
+	err = kv.CompareAndSet(version+1, 2, "counter")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = kv.CompareAndSet(version, 3, "counter")
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) || conflict.Expected != version || conflict.Actual != 3 {
+		t.Errorf("Expected a version conflict, got %v", err)
+		return
+	}
+	counter, _ := kv.Number("counter")
+	if counter != 2 {
+		t.Errorf("Expected 2, got %v", counter)
+	}
+}
+
+func TestCompareAndSetConcurrent(t *testing.T) {
+	skv := NewSync(NewFromMap(map[string]any{"counter": float64(0)}))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				for {
+					version := skv.Version("counter")
+					counter, _ := skv.Number("counter")
+					err := skv.CompareAndSet(version, counter+1, "counter")
+					if err == nil {
+						break
+					}
+					var conflict *VersionConflictError
+					if !errors.As(err, &conflict) {
+						t.Errorf("Unexpected error %v", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	counter, _ := skv.Number("counter")
+	if counter != 400 {
+		t.Errorf("Expected no lost updates, got %v", counter)
+	}
+}
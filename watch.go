@@ -0,0 +1,356 @@
+package keyval
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWatchInterval is the polling interval used when WatcherOptions doesn't specify one
+	DefaultWatchInterval = time.Second
+	// DefaultWatchDebounce is the quiet period used when WatcherOptions doesn't specify one
+	DefaultWatchDebounce = 250 * time.Millisecond
+)
+
+// WatchEvent is delivered to subscribers of a Watcher whenever the watched documents change
+type WatchEvent struct {
+	Previous *KeyVal
+	Current  *KeyVal
+	Changes  []Change
+}
+
+// WatcherOptions controls the behavior of a Watcher
+type WatcherOptions struct {
+	// Interval is how often the watched paths are polled for changes, DefaultWatchInterval if zero
+	Interval time.Duration
+	// Debounce is how long the watched paths must remain unchanged before they are reloaded, so that a burst of
+	// writes results in a single reload.  DefaultWatchDebounce is used if zero, a negative value disables debouncing.
+	Debounce time.Duration
+	// OnError is called when the watched documents can't be reloaded, in which case the previous version is kept
+	OnError func(err error)
+}
+
+// Watcher polls a set of files and directories, reloading them into a single KeyVal whenever they change.  Files are
+// stacked in the order given, each atop the last.  A directory contributes every JSON and YAML file it directly
+// contains, in name order.  Files are parsed with NewFromFile so includes are resolved, however only the watched
+// files themselves are monitored for changes.
+type Watcher struct {
+	paths       []string
+	opts        WatcherOptions
+	publisher   publisher
+	lock        sync.Mutex
+	fingerprint string
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// publisher holds the current version of a document, notifying subscribers whenever it changes.  Versions are
+// numbered in the order they began loading, and a version is dropped if a later one was published first, so that
+// concurrent loads never make an older version current.  Events are delivered in the order they were published,
+// by one goroutine at a time.
+type publisher struct {
+	lock        sync.RWMutex
+	current     *KeyVal
+	subscribers map[int]func(WatchEvent)
+	nextId      int
+	// version is the number of the most recent version begun, published that of the current version
+	version   uint64
+	published uint64
+	// pending holds the events awaiting delivery, delivering is true while a goroutine is delivering them
+	pending    []WatchEvent
+	delivering bool
+}
+
+// NewWatcher returns a new Watcher over paths, having performed the initial load.  Call Start to begin watching.
+func NewWatcher(paths []string, opts WatcherOptions) (*Watcher, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultWatchInterval
+	}
+	if opts.Debounce < 0 {
+		opts.Debounce = 0
+	} else if opts.Debounce == 0 {
+		opts.Debounce = DefaultWatchDebounce
+	}
+
+	w := &Watcher{
+		paths: paths,
+		opts:  opts,
+	}
+	fingerprint, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	kv, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+	w.publisher.current = kv
+	w.fingerprint = fingerprint
+
+	return w, nil
+}
+
+// Current returns the most recently loaded version of the watched documents, which must not be modified
+func (w *Watcher) Current() *KeyVal {
+	return w.publisher.load()
+}
+
+// Subscribe registers fn to be called with every change to the watched documents, returning a function which
+// cancels the subscription.  Subscribers are called sequentially, in the order the changes were made, from the
+// goroutine performing a reload.  If reloads run concurrently, the first of them delivers every event.
+func (w *Watcher) Subscribe(fn func(event WatchEvent)) func() {
+	return w.publisher.subscribe(fn)
+}
+
+// Start begins polling the watched paths in a background goroutine
+func (w *Watcher) Start() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stop != nil {
+		return
+	}
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.run(w.stop, w.done)
+}
+
+// Stop stops polling, waiting for any reload in progress to complete
+func (w *Watcher) Stop() {
+	w.lock.Lock()
+	stop, done := w.stop, w.done
+	w.stop, w.done = nil, nil
+	w.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Reload immediately reloads the watched documents, notifying subscribers if they changed.  A reload which completes
+// after a reload begun later is discarded.
+func (w *Watcher) Reload() error {
+	version := w.publisher.begin()
+	kv, err := w.load()
+	if err != nil {
+		return err
+	}
+	w.publisher.publishVersion(version, kv)
+	return nil
+}
+
+// run polls until stop is closed
+func (w *Watcher) run(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+
+	pending := false
+	var lastChange time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			fingerprint, err := w.scan()
+			if err != nil {
+				w.reportError(err)
+				continue
+			}
+			if fingerprint != w.fingerprint {
+				w.fingerprint = fingerprint
+				pending = true
+				lastChange = now
+			}
+			if pending && now.Sub(lastChange) >= w.opts.Debounce {
+				pending = false
+				err := w.Reload()
+				if err != nil {
+					// The files may have been read mid-write, so retry at the next poll rather than waiting for them to
+					// change again
+					w.reportError(err)
+					pending = true
+				}
+			}
+		}
+	}
+}
+
+// reportError passes err to the configured error handler
+func (w *Watcher) reportError(err error) {
+	if w.opts.OnError != nil {
+		w.opts.OnError(err)
+	}
+}
+
+// files returns the watched files, expanding directories
+func (w *Watcher) files() ([]string, error) {
+	files := []string{}
+	for _, path := range w.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		names := []string{}
+		for _, entry := range entries {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if !entry.IsDir() && (ext == ".json" || ext == ".yaml" || ext == ".yml") {
+				names = append(names, entry.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			files = append(files, filepath.Join(path, name))
+		}
+	}
+	return files, nil
+}
+
+// scan returns a fingerprint of the name, size and modification time of every watched file
+func (w *Watcher) scan() (string, error) {
+	files, err := w.files()
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "%s:%d:%d\n", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return sb.String(), nil
+}
+
+// load parses and stacks every watched file
+func (w *Watcher) load() (*KeyVal, error) {
+	files, err := w.files()
+	if err != nil {
+		return nil, err
+	}
+
+	kv := New()
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		// Use the filesystem root so that includes may refer to parent directories
+		root := filepath.VolumeName(abs) + string(filepath.Separator)
+		layer, err := NewFromFile(os.DirFS(root), filepath.ToSlash(abs[len(root):]))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load \"%s\": %v", file, err)
+		}
+		kv = kv.Stack(layer)
+	}
+	return kv, nil
+}
+
+// load returns the current version of the document
+func (p *publisher) load() *KeyVal {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.current
+}
+
+// subscribe registers fn to be called with every change to the document, returning a function which cancels the
+// subscription
+func (p *publisher) subscribe(fn func(event WatchEvent)) func() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.subscribers == nil {
+		p.subscribers = map[int]func(WatchEvent){}
+	}
+	id := p.nextId
+	p.nextId++
+	p.subscribers[id] = fn
+
+	return func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		delete(p.subscribers, id)
+	}
+}
+
+// begin returns the number of a new version of the document, to be passed to publish once it is loaded
+func (p *publisher) begin() uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.version++
+	return p.version
+}
+
+// publish makes kv the current version of the document, see publishVersion
+func (p *publisher) publish(kv *KeyVal) {
+	p.publishVersion(p.begin(), kv)
+}
+
+// publishVersion makes kv, loaded as version, the current version of the document unless a later version was already
+// published.  If it differs from the previous version, subscribers are notified in the order they subscribed, either
+// by this goroutine or by one already delivering events.
+func (p *publisher) publishVersion(version uint64, kv *KeyVal) {
+	p.lock.Lock()
+	if version <= p.published {
+		p.lock.Unlock()
+		return
+	}
+	p.published = version
+	previous := p.current
+	if previous == nil {
+		// The initial version, published before anyone could subscribe
+		p.current = kv
+		p.lock.Unlock()
+		return
+	}
+	changes := Diff(previous, kv)
+	if len(changes) == 0 {
+		p.lock.Unlock()
+		return
+	}
+	p.current = kv
+	p.pending = append(p.pending, WatchEvent{
+		Previous: previous,
+		Current:  kv,
+		Changes:  changes,
+	})
+	if p.delivering {
+		p.lock.Unlock()
+		return
+	}
+
+	p.delivering = true
+	for len(p.pending) > 0 {
+		event := p.pending[0]
+		p.pending = p.pending[1:]
+		subscribers := make([]func(WatchEvent), 0, len(p.subscribers))
+		for id := 0; id < p.nextId; id++ {
+			if fn, ok := p.subscribers[id]; ok {
+				subscribers = append(subscribers, fn)
+			}
+		}
+		p.lock.Unlock()
+
+		for _, fn := range subscribers {
+			fn(event)
+		}
+		p.lock.Lock()
+	}
+	p.delivering = false
+	p.lock.Unlock()
+}
@@ -0,0 +1,321 @@
+package keyval
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForEvent returns the next event from events, failing the test if none arrives in time
+func waitForEvent(t *testing.T, events chan WatchEvent) (WatchEvent, bool) {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event, true
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for watch event")
+		return WatchEvent{}, false
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(dir, "conf.d")
+	err := os.Mkdir(confDir, 0755)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	base := filepath.Join(dir, "base.yaml")
+	err = os.WriteFile(base, []byte("name: app\ndb:\n  host: localhost\n  port: 5432\n"), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = os.WriteFile(filepath.Join(confDir, "10-db.json"), []byte(`{"db": {"port": 5433}}`), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var lock sync.Mutex
+	errs := []error{}
+	w, err := NewWatcher([]string{base, confDir}, WatcherOptions{
+		Interval: 5 * time.Millisecond,
+		Debounce: 20 * time.Millisecond,
+		OnError: func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			errs = append(errs, err)
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	port, err := w.Current().Number("db", "port")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if port != 5433 {
+		t.Errorf("Expected 5433, got %v", port)
+		return
+	}
+
+	events := make(chan WatchEvent, 10)
+	w.Subscribe(func(event WatchEvent) {
+		events <- event
+	})
+	w.Start()
+	defer w.Stop()
+
+	// A broken file is reported and the previous version kept
+	err = os.WriteFile(filepath.Join(confDir, "20-broken.yaml"), []byte("db: [unterminated\n"), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock.Lock()
+		count := len(errs)
+		lock.Unlock()
+		if count > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("Timed out waiting for parse error")
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if w.Current().Equal(New()) {
+		t.Errorf("Expected previous version to be kept")
+		return
+	}
+
+	err = os.WriteFile(filepath.Join(confDir, "20-broken.yaml"), []byte("db:\n  host: db.example.com\n"), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	event, ok := waitForEvent(t, events)
+	if !ok {
+		return
+	}
+	if len(event.Changes) != 1 || event.Changes[0].Path != "/db/host" || event.Changes[0].New != "db.example.com" {
+		t.Errorf("Unexpected changes %v", event.Changes)
+		return
+	}
+	host, err := w.Current().String("db", "host")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if host != "db.example.com" {
+		t.Errorf("Expected db.example.com, got %s", host)
+		return
+	}
+}
+
+func TestWatcherDebounce(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(file, []byte(`{"count": 0}`), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	w, err := NewWatcher([]string{file}, WatcherOptions{
+		Interval: 5 * time.Millisecond,
+		Debounce: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	events := make(chan WatchEvent, 10)
+	w.Subscribe(func(event WatchEvent) {
+		events <- event
+	})
+	w.Start()
+	defer w.Stop()
+
+	for _, data := range []string{`{"count": 1}`, `{"count": 22}`, `{"count": 333}`} {
+		err = os.WriteFile(file, []byte(data), 0644)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	event, ok := waitForEvent(t, events)
+	if !ok {
+		return
+	}
+	count, err := event.Current.Number("count")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if count != 333 {
+		t.Errorf("Expected a single reload with the final value, got %v", count)
+		return
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Unexpected additional event %v", event.Changes)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestWatcherRetry(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(file, []byte(`{"count": 0}`), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	w, err := NewWatcher([]string{file}, WatcherOptions{
+		Interval: 5 * time.Millisecond,
+		Debounce: -1,
+		OnError:  func(err error) {},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	events := make(chan WatchEvent, 10)
+	w.Subscribe(func(event WatchEvent) {
+		events <- event
+	})
+
+	// A file seen mid-write is reloaded once complete, even if its size and modification time are unchanged
+	modified := time.Now().Add(-time.Hour)
+	err = os.WriteFile(file, []byte(`{"count": 1`), 0644)
+	if err == nil {
+		err = os.Chtimes(file, modified, modified)
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	w.Start()
+	defer w.Stop()
+	time.Sleep(50 * time.Millisecond)
+	err = os.WriteFile(file, []byte(`{"count":1}`), 0644)
+	if err == nil {
+		err = os.Chtimes(file, modified, modified)
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	event, ok := waitForEvent(t, events)
+	if !ok {
+		return
+	}
+	expectJson(t, event.Current, `{"count":1}`)
+}
+
+func TestWatcherUnsubscribe(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(file, []byte(`{"count": 0}`), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	w, err := NewWatcher([]string{file}, WatcherOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	calls := 0
+	unsubscribe := w.Subscribe(func(event WatchEvent) {
+		calls++
+	})
+	err = os.WriteFile(file, []byte(`{"count": 1}`), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = w.Reload()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	unsubscribe()
+	err = os.WriteFile(file, []byte(`{"count": 2}`), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = w.Reload()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 call, got %d", calls)
+		return
+	}
+}
+
+func TestPublisherOrdering(t *testing.T) {
+	p := publisher{}
+	p.publish(NewFromMap(map[string]any{"count": -1}))
+
+	// A version loaded before one which was already published is dropped
+	older := p.begin()
+	p.publish(NewFromMap(map[string]any{"count": 0}))
+	p.publishVersion(older, NewFromMap(map[string]any{"count": -2}))
+	expectJson(t, p.load(), `{"count":0}`)
+
+	// Events published concurrently are delivered one at a time, each following on from the last
+	var lock sync.Mutex
+	events := []WatchEvent{}
+	delivering := false
+	p.subscribe(func(event WatchEvent) {
+		lock.Lock()
+		if delivering {
+			t.Errorf("Expected events to be delivered one at a time")
+		}
+		delivering = true
+		lock.Unlock()
+		time.Sleep(time.Millisecond)
+		lock.Lock()
+		delivering = false
+		events = append(events, event)
+		lock.Unlock()
+	})
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			version := p.begin()
+			p.publishVersion(version, NewFromMap(map[string]any{"count": i}))
+		}(i)
+	}
+	wg.Wait()
+
+	if len(events) == 0 {
+		t.Errorf("Expected events")
+		return
+	}
+	for idx := 1; idx < len(events); idx++ {
+		if events[idx].Previous != events[idx-1].Current {
+			t.Errorf("Event %d doesn't follow on from the previous event", idx)
+			return
+		}
+	}
+	if events[len(events)-1].Current != p.load() {
+		t.Errorf("Expected the last event to hold the current version")
+	}
+}